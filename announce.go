@@ -0,0 +1,64 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// 向infoHash附近的节点宣告自己拥有资源
+//
+// 先迭代get_peers找到距离infoHash最近的KNODES个节点并获取token, 再向持有token的节点发送announce_peer,
+// port为0时使用implied_port, 由对方取UDP来源端口. 返回宣告成功的节点
+func (node *DHTNode) Announce(ctx context.Context, infoHash string, port int) (announced []*CompactNode, err error) {
+	var (
+		result *LookupResult
+		targets = make([]*CompactNode, 0, KNODES)
+	)
+
+	if result, err = node.GetPeersLookup(ctx, infoHash); err != nil {
+		return nil, err
+	}
+	for _, target := range result.Nodes {
+		if _, exist := result.Tokens[target.Address]; exist {
+			targets = append(targets, target)
+		}
+	}
+	if len(targets) == 0 {
+		return nil, errors.New("no node returned a token")
+	}
+	return node.announceTo(ctx, infoHash, port, targets, result.Tokens), nil
+}
+
+// 并发announce_peer
+func (node *DHTNode) announceTo(ctx context.Context, infoHash string, port int, targets []*CompactNode, tokens map[string]string) (announced []*CompactNode) {
+	var (
+		mutex sync.Mutex
+		wait sync.WaitGroup
+	)
+
+	announced = make([]*CompactNode, 0, len(targets))
+	for _, target := range targets {
+		wait.Add(1)
+		go func(target *CompactNode) {
+			defer wait.Done()
+
+			request := NewAnnouncePeerRequest()
+			request.InfoHash = infoHash
+			request.Token = tokens[target.Address]
+			if port == 0 {
+				request.ImpliedPort = 1
+			} else {
+				request.Port = port
+			}
+			if _, err := node.krpc.AnnouncePeer(ctx, request, target.Address); err != nil {
+				return
+			}
+			mutex.Lock()
+			announced = append(announced, target)
+			mutex.Unlock()
+		}(target)
+	}
+	wait.Wait()
+	return
+}
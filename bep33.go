@@ -0,0 +1,113 @@
+package dht
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"math"
+	"math/bits"
+	"net"
+)
+
+// BEP 33: get_peers带上scrape=1时, 应答附带做种者(BFsd)与下载者(BFpe)两个布隆过滤器,
+// 合并多个节点的过滤器即可估算swarm规模
+const (
+	BLOOM_FILTER_SIZE = 256 // 字节数, 即2048位
+	BLOOM_FILTER_BITS = BLOOM_FILTER_SIZE * 8
+	BLOOM_FILTER_HASHES = 2 // 每个元素置位的数量
+)
+
+// 以peer IP为元素的布隆过滤器
+type BloomFilter [BLOOM_FILTER_SIZE]byte
+
+func NewBloomFilter() *BloomFilter {
+	return &BloomFilter{}
+}
+
+// 解析应答中的BFsd, BFpe
+func UnserializeBloomFilter(bits string) (*BloomFilter, error) {
+	if len(bits) != BLOOM_FILTER_SIZE {
+		return nil, errors.New("bloom filter size invalid")
+	}
+	filter := &BloomFilter{}
+	copy(filter[:], bits)
+	return filter, nil
+}
+
+// 插入IP: 对网络字节序的4或16字节IP做SHA1, 前两组16位小端整数作为下标
+func (filter *BloomFilter) Insert(ip net.IP) {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	hash := sha1.Sum(ip)
+	for i := 0; i < BLOOM_FILTER_HASHES; i++ {
+		index := (int(hash[i * 2]) | int(hash[i * 2 + 1]) << 8) % BLOOM_FILTER_BITS
+		filter[index / 8] |= 1 << uint(index % 8)
+	}
+}
+
+// 按位或合并另一个过滤器
+func (filter *BloomFilter) Merge(other *BloomFilter) {
+	for i := 0; i < BLOOM_FILTER_SIZE; i++ {
+		filter[i] |= other[i]
+	}
+}
+
+// 估算插入过的元素数量: n = ln(c / m) / (k * ln(1 - 1 / m)), c为未置位的位数
+func (filter *BloomFilter) Estimate() int {
+	zeros := 0
+	for i := 0; i < BLOOM_FILTER_SIZE; i++ {
+		zeros += 8 - bits.OnesCount8(filter[i])
+	}
+	if zeros == BLOOM_FILTER_BITS {
+		return 0
+	}
+	if zeros == 0 { // 已饱和, 按只剩1位估算上限
+		zeros = 1
+	}
+	m := float64(BLOOM_FILTER_BITS)
+	n := math.Log(float64(zeros) / m) / (BLOOM_FILTER_HASHES * math.Log(1 - 1 / m))
+	return int(math.Round(n))
+}
+
+func (filter *BloomFilter) String() string {
+	return string(filter[:])
+}
+
+// 估算的swarm规模
+type ScrapeResult struct {
+	Seeders int // 做种者数量
+	Leechers int // 下载者数量
+	Seeds *BloomFilter // 合并后的BFsd
+	Peers *BloomFilter // 合并后的BFpe
+	Nodes []*CompactNode // 参与合并的节点
+}
+
+// 迭代get_peers(scrape=1), 合并距离infoHash最近的KNODES个节点返回的过滤器, 估算做种者与下载者数量
+func (node *DHTNode) Scrape(ctx context.Context, infoHash string) (*ScrapeResult, error) {
+	result, err := node.lookup(ctx, infoHash, LOOKUP_SCRAPE, node.closestSeeds(infoHash))
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Nodes) == 0 {
+		return nil, errors.New("no node found")
+	}
+	return result.Scrape(), nil
+}
+
+// 由scrape模式的查询结果估算swarm规模
+func (result *LookupResult) Scrape() *ScrapeResult {
+	scrape := &ScrapeResult{}
+	scrape.Seeds = NewBloomFilter()
+	scrape.Peers = NewBloomFilter()
+	scrape.Nodes = result.Nodes
+	if result.BFsd != nil {
+		scrape.Seeds.Merge(result.BFsd)
+	}
+	if result.BFpe != nil {
+		scrape.Peers.Merge(result.BFpe)
+	}
+	scrape.Seeders = scrape.Seeds.Estimate()
+	scrape.Leechers = scrape.Peers.Estimate()
+	return scrape
+}
@@ -0,0 +1,183 @@
+package dht
+
+import (
+	"crypto/rand"
+	"hash/crc32"
+	"net"
+	"sync"
+)
+
+// BEP 42: 节点ID的前21位由外部IP的CRC32-C决定, 使攻击者无法任意选择ID
+const (
+	// 路由表对不符合BEP 42的节点的处理策略
+	SECURE_ID_IGNORE = 0 // 不区分
+	SECURE_ID_PREFER = 1 // 桶满时, 符合的节点可以替换不符合的节点
+	SECURE_ID_ENFORCE = 2 // 拒绝不符合的节点
+
+	EXTERNAL_IP_MIN_VOTES = 5 // 确定外部IP至少需要的票数
+	EXTERNAL_IP_MAX_VOTERS = 100 // 保留最近的投票方数量
+)
+
+var (
+	castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+	secureIdMask4 = []byte{0x03, 0x0f, 0x3f, 0xff}
+	secureIdMask6 = []byte{0x01, 0x03, 0x07, 0x0f, 0x1f, 0x3f, 0x7f, 0xff}
+)
+
+// 局域网, 回环等地址不要求符合BEP 42
+func isLocalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified()
+}
+
+// (ip & mask) | r << 5 的CRC32-C
+func secureIdCRC(ip net.IP, r byte) uint32 {
+	var (
+		mask []byte
+		masked []byte
+	)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, mask = ip4, secureIdMask4
+	} else {
+		mask = secureIdMask6
+	}
+	masked = make([]byte, len(mask))
+	for i := range mask {
+		masked[i] = ip[i] & mask[i]
+	}
+	masked[0] |= r << 5
+	return crc32.Checksum(masked, castagnoliTable)
+}
+
+// 按外部IP生成符合BEP 42的节点ID
+func GenSecureNodeId(ip net.IP) string {
+	var id [20]byte
+	for {
+		if _, err := rand.Read(id[:]); err == nil {
+			break
+		}
+	}
+	crc := secureIdCRC(ip, id[19] & 0x7)
+	id[0] = byte(crc >> 24)
+	id[1] = byte(crc >> 16)
+	id[2] = byte(crc >> 8) & 0xf8 | id[2] & 0x7
+	return string(id[:])
+}
+
+// 节点ID是否与其IP相符, 局域网地址总是相符
+func IsSecureNodeId(id string, ip net.IP) bool {
+	if len(id) != len(NodeID{}) || ip == nil {
+		return false
+	}
+	if isLocalIP(ip) {
+		return true
+	}
+	crc := secureIdCRC(ip, id[19] & 0x7)
+	return id[0] == byte(crc >> 24) && id[1] == byte(crc >> 16) && id[2] & 0xf8 == byte(crc >> 8) & 0xf8
+}
+
+// 根据应答中的ip字段投票决定自己的外部IP, 每个投票方IP只保留最近一票
+type ipVoter struct {
+	mutex sync.Mutex
+	votes map[string]string // 投票方IP -> 它看到的我们的IP
+	voters []string // 投票顺序, 越靠后越新
+	external string // 当前得票最多的IP
+}
+
+func newIPVoter() *ipVoter {
+	return &ipVoter{votes: make(map[string]string)}
+}
+
+// 记录一票, 外部IP发生变化时返回新IP与true
+func (voter *ipVoter) vote(voterIP string, externalIP string) (string, bool) {
+	voter.mutex.Lock()
+	defer voter.mutex.Unlock()
+
+	if _, exist := voter.votes[voterIP]; exist {
+		for i, ip := range voter.voters {
+			if ip == voterIP {
+				voter.voters = append(voter.voters[:i], voter.voters[i + 1:]...)
+				break
+			}
+		}
+	}
+	voter.votes[voterIP] = externalIP
+	voter.voters = append(voter.voters, voterIP)
+	if len(voter.voters) > EXTERNAL_IP_MAX_VOTERS {
+		delete(voter.votes, voter.voters[0])
+		voter.voters = voter.voters[1:]
+	}
+
+	// 需要达到最低票数且超过半数
+	var (
+		counts = make(map[string]int)
+		winner string
+	)
+	for _, ip := range voter.votes {
+		counts[ip]++
+		if counts[ip] > counts[winner] {
+			winner = ip
+		}
+	}
+	if counts[winner] < EXTERNAL_IP_MIN_VOTES || counts[winner] * 2 <= len(voter.votes) || winner == voter.external {
+		return voter.external, false
+	}
+	voter.external = winner
+	return winner, true
+}
+
+func (voter *ipVoter) current() string {
+	voter.mutex.Lock()
+	defer voter.mutex.Unlock()
+	return voter.external
+}
+
+// 投票得出的外部IP, 优先IPv4, 尚未确定时返回nil
+func (node *DHTNode) ExternalIP() net.IP {
+	if ip := node.voter4.current(); ip != "" {
+		return net.ParseIP(ip)
+	}
+	if ip := node.voter6.current(); ip != "" {
+		return net.ParseIP(ip)
+	}
+	return nil
+}
+
+// 处理应答中的ip字段(紧凑格式); 外部IP变化且当前ID不再相符时重新生成ID
+func (node *DHTNode) voteExternalIP(compactIP string, voterAddr *net.UDPAddr) {
+	var (
+		address string
+		host string
+		external net.IP
+		voter *ipVoter
+		err error
+	)
+	if address, err = UnserializePeerInfo(compactIP); err != nil {
+		return
+	}
+	if host, _, err = net.SplitHostPort(address); err != nil {
+		return
+	}
+	if external = net.ParseIP(host); external == nil || isIPv6(external) != isIPv6(voterAddr.IP) {
+		return
+	}
+
+	if voter = node.voter4; isIPv6(external) {
+		voter = node.voter6
+	}
+	if _, changed := voter.vote(voterAddr.IP.String(), external.String()); !changed || !node.secureId {
+		return
+	}
+	// 双栈时以IPv4地址为准
+	if isIPv6(external) && node.voter4.current() != "" {
+		return
+	}
+	if !IsSecureNodeId(node.Id(), external) {
+		node.setId(GenSecureNodeId(external))
+	}
+}
+
+// 请求方地址的紧凑格式, 用于应答的ip字段
+func compactAddress(addr *net.UDPAddr) string {
+	compact, _ := SerializePeerInfo(formatAddress(addr.IP, addr.Port))
+	return compact
+}
@@ -0,0 +1,126 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// 发布item(BEP 44): 迭代find_node找到距离target最近的KNODES个节点, 逐个get取得token后put.
+// 返回保存成功的节点, 全部失败时返回其中一个错误(例如*KRPCError的ERROR_SEQ_TOO_SMALL)
+func (node *DHTNode) PutItem(ctx context.Context, item *Item) (stored []*CompactNode, err error) {
+	var (
+		target string
+		result *LookupResult
+		mutex sync.Mutex
+		wait sync.WaitGroup
+		putErr error
+	)
+	if err = item.Verify(); err != nil {
+		return nil, err
+	}
+	if target, err = item.Target(); err != nil {
+		return nil, err
+	}
+	if result, err = node.FindNodeLookup(ctx, target); err != nil {
+		return nil, err
+	}
+	if len(result.Nodes) == 0 {
+		return nil, errors.New("no node found")
+	}
+
+	stored = make([]*CompactNode, 0, len(result.Nodes))
+	for _, compactNode := range result.Nodes {
+		wait.Add(1)
+		go func(compactNode *CompactNode) {
+			defer wait.Done()
+
+			err := node.putTo(ctx, target, item, compactNode.Address)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err == nil {
+				stored = append(stored, compactNode)
+			} else {
+				putErr = err
+			}
+		}(compactNode)
+	}
+	wait.Wait()
+
+	if len(stored) == 0 {
+		return nil, putErr
+	}
+	return stored, nil
+}
+
+// 先get取得token, 再put
+func (node *DHTNode) putTo(ctx context.Context, target string, item *Item, address string) (err error) {
+	var response *GetResponse
+
+	getRequest := NewGetRequest()
+	getRequest.Target = target
+	if response, err = node.krpc.Get(ctx, getRequest, address); err != nil {
+		return
+	}
+	putRequest := NewPutRequest()
+	putRequest.Token = response.Token
+	putRequest.Item = item
+	_, err = node.krpc.Put(ctx, putRequest, address)
+	return
+}
+
+// 获取item(BEP 44): 向target附近的节点get, 不可变item校验哈希, 可变item校验签名后取seq最大的.
+// 可变item需要提供put时的salt, 找不到时返回ErrItemNotFound
+func (node *DHTNode) GetItem(ctx context.Context, target string, salt string) (item *Item, err error) {
+	var (
+		result *LookupResult
+		mutex sync.Mutex
+		wait sync.WaitGroup
+	)
+
+	// 接受校验通过且更新的item
+	accept := func(candidate *Item) {
+		if candidate.IsMutable() {
+			candidate.Salt = salt
+		}
+		if candidate.Verify() != nil {
+			return
+		}
+		if candidateTarget, err := candidate.Target(); err != nil || candidateTarget != target {
+			return
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		if item == nil || candidate.IsMutable() && candidate.Seq > item.Seq {
+			item = candidate
+		}
+	}
+
+	if local := node.itemStore.Get(target); local != nil {
+		copied := *local
+		accept(&copied)
+	}
+
+	if result, err = node.FindNodeLookup(ctx, target); err != nil {
+		return nil, err
+	}
+	for _, compactNode := range result.Nodes {
+		wait.Add(1)
+		go func(compactNode *CompactNode) {
+			defer wait.Done()
+
+			request := NewGetRequest()
+			request.Target = target
+			if response, err := node.krpc.Get(ctx, request, compactNode.Address); err == nil && response.Item != nil {
+				accept(response.Item)
+			}
+		}(compactNode)
+	}
+	wait.Wait()
+
+	if item == nil {
+		return nil, ErrItemNotFound
+	}
+	return item, nil
+}
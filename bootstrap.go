@@ -0,0 +1,107 @@
+package dht
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+const (
+	BOOTSTRAP_MIN_BACKOFF = time.Duration(1) * time.Second // 首次重试等待
+	BOOTSTRAP_MAX_BACKOFF = time.Duration(1) * time.Minute // 重试等待上限
+)
+
+// 引导进度, 每轮查询结束后回调一次
+type BootstrapProgress struct {
+	Attempt int // 第几轮
+	Resolved int // 解析成功的引导节点地址数
+	Responded int // 本轮应答的最近节点数
+	TableSize int // 路由表节点数(IPv4 + IPv6)
+	Backoff time.Duration // 路由表仍为空时, 下一轮前的等待时间
+	Err error // 本轮的错误
+}
+
+// 加入DHT网络: 解析引导节点, 迭代查询自己的ID, 沿途应答的节点进入路由表;
+// 路由表仍为空则指数退避后重试, 直到成功或ctx结束. addrs为空时使用Options.BootstrapNodes
+func (node *DHTNode) Bootstrap(ctx context.Context, addrs []string) (err error) {
+	var (
+		seeds []*CompactNode
+		result *LookupResult
+		progress *BootstrapProgress
+		backoff = BOOTSTRAP_MIN_BACKOFF
+	)
+	if len(addrs) == 0 {
+		addrs = node.bootstrapNodes
+	}
+
+	for attempt := 1; ; attempt++ {
+		progress = &BootstrapProgress{Attempt: attempt}
+
+		// 每轮重新解析, 引导节点的域名可能对应多个变化的IP
+		seeds = node.resolveBootstrapNodes(ctx, addrs)
+		progress.Resolved = len(seeds)
+		seeds = append(seeds, node.closestSeeds(node.Id())...)
+
+		if result, err = node.lookup(ctx, node.Id(), LOOKUP_FIND_NODE, seeds); err == nil {
+			progress.Responded = len(result.Nodes)
+		}
+		progress.Err = err
+		progress.TableSize = node.routingTable.NodeCount() + node.routingTable6.NodeCount()
+
+		if progress.TableSize > 0 {
+			node.reportBootstrap(progress)
+			return nil
+		}
+		if ctx.Err() != nil {
+			node.reportBootstrap(progress)
+			return ctx.Err()
+		}
+
+		progress.Backoff = backoff
+		node.reportBootstrap(progress)
+		select {
+		case <- time.After(backoff):
+		case <- ctx.Done():
+			return ctx.Err()
+		}
+		if backoff *= 2; backoff > BOOTSTRAP_MAX_BACKOFF {
+			backoff = BOOTSTRAP_MAX_BACKOFF
+		}
+	}
+}
+
+// 解析引导节点地址, 引导节点的ID未知; ctx结束时停止解析, 返回已解析的部分
+func (node *DHTNode) resolveBootstrapNodes(ctx context.Context, addrs []string) (seeds []*CompactNode) {
+	var (
+		host string
+		port string
+		ipAddrs []net.IPAddr
+		err error
+	)
+	seeds = make([]*CompactNode, 0, len(addrs))
+	for _, addr := range addrs {
+		if host, port, err = net.SplitHostPort(addr); err != nil {
+			continue
+		}
+		if ipAddrs, err = net.DefaultResolver.LookupIPAddr(ctx, host); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+		for _, ipAddr := range ipAddrs {
+			ip := ipAddr.IP
+			if isIPv6(ip) && node.krpc.network == "udp4" || !isIPv6(ip) && node.krpc.network == "udp6" {
+				continue
+			}
+			seeds = append(seeds, &CompactNode{Address: net.JoinHostPort(ip.String(), port)})
+		}
+	}
+	return
+}
+
+func (node *DHTNode) reportBootstrap(progress *BootstrapProgress) {
+	if node.onBootstrapProgress != nil {
+		node.onBootstrapProgress(progress)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"github.com/owenliang/dht"
+	"os"
+	"fmt"
+	"flag"
+	"time"
+	"encoding/json"
+	"text/tabwriter"
+)
+
+const (
+	SNAPSHOT_INTERVAL = 30 * time.Second // 运行中写出路由表快照的间隔
+)
+
+// 定期把运行中节点的路由表快照写到path, 供crawler dump查看
+func snapshotLoop(node *dht.DHTNode, path string) {
+	for {
+		if err := node.Snapshot().Save(path); err != nil {
+			fmt.Fprintln(os.Stderr, "snapshot", err)
+		}
+		time.Sleep(SNAPSHOT_INTERVAL)
+	}
+}
+
+// 打印运行中的crawler写出的路由表快照, 排查节点连通性
+func dump(args []string) int {
+	var (
+		flags = flag.NewFlagSet("dump", flag.ExitOnError)
+		asJSON = flags.Bool("json", false, "print as JSON")
+		snapshot *dht.DHTSnapshot
+		err error
+	)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: crawler dump [-json] <snapshot file>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	if snapshot, err = dht.LoadDHTSnapshot(flags.Arg(0)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err = encoder.Encode(snapshot); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+	fmt.Printf("id %s, snapshot taken %s ago\n", snapshot.Id, time.Since(snapshot.Time).Truncate(time.Second))
+	printSnapshot("IPv4", snapshot.IPv4, snapshot.Time)
+	printSnapshot("IPv6", snapshot.IPv6, snapshot.Time)
+	return 0
+}
+
+// 空闲时间以生成快照的时间为准
+func printSnapshot(family string, snapshot *dht.RoutingTableSnapshot, now time.Time) {
+	if snapshot == nil {
+		return
+	}
+	fmt.Printf("\n%s: %d nodes in %d buckets\n", family, snapshot.NodeCount, len(snapshot.Buckets))
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, bucket := range snapshot.Buckets {
+		prefix := bucket.Prefix
+		if prefix == "" {
+			prefix = "*"
+		}
+		fmt.Fprintf(writer, "\nbucket %d, prefix %s, nodes %d/%d, replacements %d, idle %s\n",
+			bucket.Index, prefix, len(bucket.Nodes), dht.KNODES, bucket.Replacements, now.Sub(bucket.LastActive).Truncate(time.Second))
+		for _, node := range bucket.Nodes {
+			fmt.Fprintf(writer, "  %s\t%s\t%s\tfail %d\tidle %s\n",
+				node.Id, node.Address, node.Status, node.FailTimes, now.Sub(node.LastActive).Truncate(time.Second))
+		}
+	}
+	writer.Flush()
+}
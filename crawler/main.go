@@ -4,26 +4,71 @@ import (
 	"github.com/owenliang/dht"
 	"os"
 	"fmt"
+	"flag"
 	"context"
 	"time"
 )
 
+// 用法:
+//	crawler [-state 路由表文件] [-snapshot 快照文件] [-ro] [-sample]
+//	crawler dump [-json] 快照文件
 func main()  {
 	var (
 		krpc *dht.KRPC
 		err error
 		nodes = make(chan *dht.CompactNode, 10000)
-		bootstrap  = "router.bittorrent.com:6881"
+		bootstrap  = dht.DefaultBootstrapNodes[0]
+		stateFile = flag.String("state", "", "routing table file, saved periodically and restored on start")
+		snapshotFile = flag.String("snapshot", "", "write routing table snapshots as JSON for 'crawler dump' (default <state>.snapshot.json with -state)")
+		readOnly = flag.Bool("ro", false, "read-only mode (BEP 43), do not answer queries")
+		sample = flag.Bool("sample", false, "crawl infohashes with sample_infohashes (BEP 51) instead of waiting for announces")
 	)
-	if krpc, err = dht.CreateKPRC(); err != nil {
+	if len(os.Args) > 1 && os.Args[1] == "dump" {
+		os.Exit(dump(os.Args[2:]))
+	}
+	flag.Parse()
+
+	if *stateFile == "" && !*readOnly {
+		krpc, err = dht.CreateKPRC()
+	} else {
+		options := dht.DefaultOptions()
+		options.StateFile = *stateFile
+		options.ReadOnly = *readOnly
+		krpc, err = dht.NewKRPC(options)
+	}
+	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	if *snapshotFile == "" && *stateFile != "" {
+		*snapshotFile = *stateFile + ".snapshot.json"
+	}
+	if *snapshotFile != "" {
+		go snapshotLoop(krpc.Node(), *snapshotFile)
+	}
+
+	// 加入网络
+	bootstrapCtx, cancelFunc := context.WithTimeout(context.Background(), time.Duration(1) * time.Minute)
+	err = krpc.Node().Bootstrap(bootstrapCtx, nil)
+	cancelFunc()
+	if err != nil {
+		fmt.Println("bootstrap", err)
+	}
+
+	// 主动遍历各节点保存的infohash
+	if *sample {
+		sampleCrawl(krpc, bootstrap)
+		for {
+			time.Sleep(1 * time.Second)
+		}
+	}
 
 	// 实际上, 做一个爬虫并不需要维护路由表, 而只需要尽快加入到更多节点的路由表中
 
 	// 不停的find_node, 让更多人认识我
-	nodes <- &dht.CompactNode{bootstrap, ""}
+	for _, node := range krpc.Node().RoutingTable().ClosestNodes(krpc.NodeId(), dht.KNODES) {
+		nodes <- node
+	}
 	for i := 0; i < 3000; i++ {
 		go func() {
 			var (
@@ -40,7 +85,7 @@ func main()  {
 				default:
 				}
 				if node == nil {
-					node = &dht.CompactNode{bootstrap, ""}
+					node = &dht.CompactNode{Address: bootstrap}
 				}
 
 				findNodeReq = dht.NewFindNodeRequest()
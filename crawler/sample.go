@@ -0,0 +1,105 @@
+package main
+
+import (
+	"github.com/owenliang/dht"
+	"fmt"
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	SAMPLE_WORKERS = 64 // 并发请求数
+	SAMPLE_RETRY = time.Duration(10) * time.Minute // 请求失败或不支持BEP 51的节点, 隔多久再试
+	SAMPLE_MAX_SEEN = 1000000 // 去重集合上限, 超过后清空
+)
+
+// BEP 51: 向节点请求随机target的sample_infohashes, 应答中的节点继续加入队列, 从而遍历整个ID空间;
+// 同一地址在对方要求的interval内不会再次请求
+func sampleCrawl(krpc *dht.KRPC, bootstrap string) {
+	var (
+		queue = make(chan *dht.CompactNode, 100000)
+		mutex sync.Mutex
+		nextQuery = make(map[string]time.Time) // 地址 -> 允许再次请求的时间
+		seen = make(map[string]bool) // 已输出的infohash
+	)
+
+	enqueue := func(node *dht.CompactNode) {
+		select {
+		case queue <- node:
+		default:
+		}
+	}
+
+	// 检查并占用请求时机, 避免多个协程同时请求同一地址
+	acquire := func(address string, now time.Time) bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if now.Before(nextQuery[address]) {
+			return false
+		}
+		nextQuery[address] = now.Add(SAMPLE_RETRY)
+		if len(nextQuery) > SAMPLE_MAX_SEEN { // 清理已经可以再次请求的地址
+			for address, next := range nextQuery {
+				if now.After(next) {
+					delete(nextQuery, address)
+				}
+			}
+		}
+		return true
+	}
+
+	for i := 0; i < SAMPLE_WORKERS; i++ {
+		go func() {
+			var (
+				node *dht.CompactNode
+				request *dht.SampleInfohashesRequest
+				response *dht.SampleInfohashesResponse
+				err error
+			)
+			for {
+				select {
+				case node = <- queue:
+				default:
+					// 队列空了, 从路由表和引导节点重新开始
+					for _, node = range krpc.Node().RoutingTable().ClosestNodes(dht.GenNodeId(), dht.KNODES) {
+						enqueue(node)
+					}
+					enqueue(&dht.CompactNode{Address: bootstrap})
+					time.Sleep(time.Duration(1) * time.Second)
+					continue
+				}
+				if !acquire(node.Address, time.Now()) {
+					continue
+				}
+
+				request = dht.NewSampleInfohashesRequest()
+				request.Target = dht.GenNodeId()
+				if response, err = krpc.SampleInfohashes(context.Background(), request, node.Address); err != nil {
+					continue
+				}
+
+				mutex.Lock()
+				nextQuery[node.Address] = time.Now().Add(time.Duration(response.Interval) * time.Second)
+				if len(seen) > SAMPLE_MAX_SEEN {
+					seen = make(map[string]bool)
+				}
+				for _, infoHash := range response.Samples {
+					if !seen[infoHash] {
+						seen[infoHash] = true
+						fmt.Println("sample", (&dht.PeerEvent{InfoHash: infoHash}).Magnet(), node.Address)
+					}
+				}
+				mutex.Unlock()
+
+				for _, node = range response.Nodes {
+					enqueue(node)
+				}
+				for _, node = range response.Nodes6 {
+					enqueue(node)
+				}
+			}
+		}()
+	}
+}
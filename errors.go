@@ -0,0 +1,78 @@
+package dht
+
+import (
+	"fmt"
+	"errors"
+)
+
+var (
+	ErrTimeout = errors.New("krpc request timeout") // RPC超时; 调用方取消ctx时返回ctx.Err()
+	ErrClosed = errors.New("krpc closed") // KRPC已关闭
+	ErrInvalidResponse = errors.New("krpc invalid response") // 应答格式错误
+	ErrTransactionIdInUse = errors.New("krpc transaction id in use") // 随机请求ID与在途的请求相同
+	ErrInvalidNodeId = errors.New("node id must be 20 bytes") // Options.NodeId不是20字节的二进制ID
+)
+
+// KRPC错误码
+const (
+	ERROR_GENERIC = 201 // 一般错误
+	ERROR_SERVER = 202 // 服务端错误
+	ERROR_PROTOCOL = 203 // 协议错误, 例如包格式错误, 参数缺失, token无效
+	ERROR_METHOD_UNKNOWN = 204 // 未知方法
+
+	// BEP 44
+	ERROR_MESSAGE_TOO_BIG = 205 // v超过1000字节
+	ERROR_INVALID_SIGNATURE = 206 // 签名无效
+	ERROR_SALT_TOO_BIG = 207 // salt超过64字节
+	ERROR_CAS_MISMATCH = 301 // cas与当前seq不一致
+	ERROR_SEQ_TOO_SMALL = 302 // seq小于当前seq
+)
+
+// KRPC错误(y=e), 远端返回的错误以*KRPCError交给调用者
+type KRPCError struct {
+	Code int
+	Message string
+}
+
+func NewKRPCError(code int, message string) *KRPCError {
+	return &KRPCError{Code: code, Message: message}
+}
+
+func (err *KRPCError) Error() string {
+	return fmt.Sprintf("krpc error %d: %s", err.Code, err.Message)
+}
+
+// 错误应答
+type ErrorResponse struct {
+	ProtocolBase
+	Code int
+	Message string
+	IP string // ip: 请求方的外部地址, 紧凑格式 (BEP 42)
+}
+
+// 处理请求的错误转换为错误应答, 非KRPCError视为服务端错误
+func NewErrorResponse(transactionId string, err error) (response *ErrorResponse) {
+	response = &ErrorResponse{}
+	response.TransactionId = transactionId
+	response.Type = "e"
+	if krpcErr, typeOk := err.(*KRPCError); typeOk {
+		response.Code = krpcErr.Code
+		response.Message = krpcErr.Message
+	} else {
+		response.Code = ERROR_SERVER
+		response.Message = err.Error()
+	}
+	return response
+}
+
+func (response *ErrorResponse) Serialize() ([]byte, error) {
+	resp := map[string]interface{}{}
+
+	resp["t"] = response.TransactionId
+	resp["y"] = "e"
+	resp["e"] = []interface{}{response.Code, response.Message}
+	if len(response.IP) != 0 {
+		resp["ip"] = response.IP
+	}
+	return Encode(resp)
+}
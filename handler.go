@@ -2,12 +2,10 @@ package dht
 
 import (
 	"net"
-	"errors"
-	"fmt"
-	"encoding/hex"
+	"time"
 )
 
-func ActiveNode(addDict map[string]interface{},  packetFrom *net.UDPAddr) {
+func (node *DHTNode) ActiveNode(addDict map[string]interface{},  packetFrom *net.UDPAddr) {
 	var (
 		iField interface{}
 		id string
@@ -20,18 +18,56 @@ func ActiveNode(addDict map[string]interface{},  packetFrom *net.UDPAddr) {
 	if id, typeOk = iField.(string); !typeOk {
 		return
 	}
-	GetRoutingTable().InsertNode(NewCompactNode(id, packetFrom))
+	node.routingTableFor(packetFrom.IP).InsertNode(NewCompactNode(id, packetFrom))
 }
 
-func HandlePing(transactionId string, addDict map[string]interface{},  packetFrom *net.UDPAddr) ([]byte, error)  {
-	ActiveNode(addDict, packetFrom)
+// 请求超时, 对应地址的节点累计一次失败
+func (node *DHTNode) FailNode(requestTo *net.UDPAddr) {
+	node.routingTableFor(requestTo.IP).FailAddress(formatAddress(requestTo.IP, requestTo.Port))
+}
 
+// 解析want参数(BEP 32), 缺省时只返回与请求方相同地址族的节点
+func parseWant(addDict map[string]interface{}, packetFrom *net.UDPAddr) (wantNodes bool, wantNodes6 bool) {
+	var (
+		iField interface{}
+		iList []interface{}
+		family string
+		exist bool
+		typeOk bool
+	)
+	if iField, exist = addDict["want"]; exist {
+		if iList, typeOk = iField.([]interface{}); typeOk {
+			for _, iField = range iList {
+				if family, typeOk = iField.(string); !typeOk {
+					continue
+				}
+				if family == WANT_NODES {
+					wantNodes = true
+				} else if family == WANT_NODES6 {
+					wantNodes6 = true
+				}
+			}
+		}
+	}
+	if !wantNodes && !wantNodes6 {
+		if isIPv6(packetFrom.IP) {
+			wantNodes6 = true
+		} else {
+			wantNodes = true
+		}
+	}
+	return
+}
+
+func (node *DHTNode) HandlePing(transactionId string, addDict map[string]interface{},  packetFrom *net.UDPAddr) ([]byte, error)  {
 	resp := &PingResponse{}
 	resp.TransactionId = transactionId
+	resp.Id = node.Id()
+	resp.IP = compactAddress(packetFrom)
 	return resp.Serialize()
 }
 
-func HandleFindNode(transactionId string, addDict map[string]interface{},  packetFrom *net.UDPAddr) ([]byte, error)  {
+func (node *DHTNode) HandleFindNode(transactionId string, addDict map[string]interface{},  packetFrom *net.UDPAddr) ([]byte, error)  {
 	var (
 		iField interface{}
 		target string
@@ -40,114 +76,342 @@ func HandleFindNode(transactionId string, addDict map[string]interface{},  packe
 		targetNode *CompactNode
 	)
 
-	ActiveNode(addDict, packetFrom)
-
 	resp := &FindNodeResponse{}
 	resp.TransactionId = transactionId
+	resp.Id = node.Id()
+	resp.IP = compactAddress(packetFrom)
 
 	if iField, exist = addDict["target"]; !exist {
-		return nil, errors.New("missing target field")
+		return nil, NewKRPCError(ERROR_PROTOCOL, "missing target field")
 	}
 
 	if target, typeOk = iField.(string); !typeOk {
-		return nil, errors.New("target type invalid")
+		return nil, NewKRPCError(ERROR_PROTOCOL, "target type invalid")
 	}
 
-	if targetNode = GetRoutingTable().FindNode(target); targetNode != nil {
-		resp.Nodes = make([]*CompactNode, 1)
-		resp.Nodes[0] = targetNode
-	} else {
-		resp.Nodes = GetRoutingTable().ClosestNodes(target)
+	// 目标就是请求方自己时(例如引导时查找自己的ID), 返回最近的节点而不是它自己
+	requester := formatAddress(packetFrom.IP, packetFrom.Port)
+	wantNodes, wantNodes6 := parseWant(addDict, packetFrom)
+	if wantNodes {
+		if targetNode = node.routingTable.FindNode(target); targetNode != nil && targetNode.Address != requester {
+			resp.Nodes = []*CompactNode{targetNode}
+		} else {
+			resp.Nodes = node.routingTable.ClosestNodes(target, KNODES)
+		}
+	}
+	if wantNodes6 {
+		if targetNode = node.routingTable6.FindNode(target); targetNode != nil && targetNode.Address != requester {
+			resp.Nodes6 = []*CompactNode{targetNode}
+		} else {
+			resp.Nodes6 = node.routingTable6.ClosestNodes(target, KNODES)
+		}
 	}
 	return resp.Serialize()
 }
 
-func HandleGetPeer(transactionId string, addDict map[string]interface{},  packetFrom *net.UDPAddr) ([]byte, error)  {
+func (node *DHTNode) HandleGetPeer(transactionId string, addDict map[string]interface{},  packetFrom *net.UDPAddr) ([]byte, error)  {
 	var (
 		iField interface{}
 		infoHash string
+		nodeId string
+		scrape int = 0
+		noSeed int = 0
 		exist bool
 		typeOk bool
 	)
 
-	ActiveNode(addDict, packetFrom)
-
 	resp := &GetPeersResponse{}
 	resp.TransactionId = transactionId
+	resp.Id = node.Id()
+	resp.IP = compactAddress(packetFrom)
+	resp.Token = node.tokenMgr.GetToken()
+
+	if iField, exist = addDict["id"]; !exist {
+		return nil, NewKRPCError(ERROR_PROTOCOL, "missing id field")
+	}
+	if nodeId, typeOk = iField.(string); !typeOk {
+		return nil, NewKRPCError(ERROR_PROTOCOL, "id type invalid")
+	}
 
 	if iField, exist = addDict["info_hash"]; !exist {
-		return nil, errors.New("missing info_hash field")
+		return nil, NewKRPCError(ERROR_PROTOCOL, "missing info_hash field")
 	}
 
 	if infoHash, typeOk = iField.(string); !typeOk {
-		return nil, errors.New("info_hash type invalid")
+		return nil, NewKRPCError(ERROR_PROTOCOL, "info_hash type invalid")
 	}
 
-	// 暂时没保存peer，只能找到nodes
-	resp.Nodes = GetRoutingTable().ClosestNodes(infoHash)
+	// BEP 33
+	if iField, exist = addDict["scrape"]; exist {
+		if scrape, typeOk = iField.(int); !typeOk {
+			return nil, NewKRPCError(ERROR_PROTOCOL, "scrape type invalid")
+		}
+	}
+	if iField, exist = addDict["noseed"]; exist {
+		if noSeed, typeOk = iField.(int); !typeOk {
+			return nil, NewKRPCError(ERROR_PROTOCOL, "noseed type invalid")
+		}
+	}
+
+	// 经过我们的查询也是infohash的来源
+	node.HandlePeerInfo(&PeerEvent{
+		Type: PEER_EVENT_LOOKUP,
+		InfoHash: infoHash,
+		NodeId: nodeId,
+		Source: packetFrom,
+		Time: time.Now(),
+	})
+
+	// 有peer则返回values, 否则返回nodes
+	wantNodes, wantNodes6 := parseWant(addDict, packetFrom)
+	resp.Values = node.peerStore.GetPeers(infoHash, MAX_PEER_VALUES, wantNodes, wantNodes6, noSeed == 1)
+	if scrape == 1 {
+		resp.BFsd, resp.BFpe = node.peerStore.ScrapeFilters(infoHash)
+	}
+	if wantNodes {
+		resp.Nodes = node.routingTable.ClosestNodes(infoHash, KNODES)
+	}
+	if wantNodes6 {
+		resp.Nodes6 = node.routingTable6.ClosestNodes(infoHash, KNODES)
+	}
 
 	return resp.Serialize()
 }
 
-func HandleAnnouncePeer(transactionId string, addDict map[string]interface{},  packetFrom *net.UDPAddr) ([]byte, error)  {
+// BEP 51: 返回peer store中的部分infohash, 以及距离target最近的节点
+func (node *DHTNode) HandleSampleInfohashes(transactionId string, addDict map[string]interface{},  packetFrom *net.UDPAddr) ([]byte, error)  {
+	var (
+		iField interface{}
+		target string
+		exist bool
+		typeOk bool
+	)
+
+	resp := &SampleInfohashesResponse{}
+	resp.TransactionId = transactionId
+	resp.Id = node.Id()
+	resp.IP = compactAddress(packetFrom)
+
+	if iField, exist = addDict["target"]; !exist {
+		return nil, NewKRPCError(ERROR_PROTOCOL, "missing target field")
+	}
+	if target, typeOk = iField.(string); !typeOk {
+		return nil, NewKRPCError(ERROR_PROTOCOL, "target type invalid")
+	}
+
+	resp.Interval = SAMPLE_INTERVAL
+	resp.Num = node.peerStore.InfoHashCount()
+	resp.Samples = node.peerStore.SampleInfoHashes(MAX_SAMPLES)
+
+	wantNodes, wantNodes6 := parseWant(addDict, packetFrom)
+	if wantNodes {
+		resp.Nodes = node.routingTable.ClosestNodes(target, KNODES)
+	}
+	if wantNodes6 {
+		resp.Nodes6 = node.routingTable6.ClosestNodes(target, KNODES)
+	}
+	return resp.Serialize()
+}
+
+func (node *DHTNode) HandleAnnouncePeer(transactionId string, addDict map[string]interface{},  packetFrom *net.UDPAddr) ([]byte, error)  {
 	var (
 		iField interface{}
 		infoHash string
+		nodeId string
 		token string
 		impliedPort int = 0
 		port int
+		seed int = 0
 		exist bool
 		typeOk bool
 	)
 
-	ActiveNode(addDict, packetFrom)
-
 	resp := &AnnouncePeerResponse{}
 	resp.TransactionId = transactionId
+	resp.Id = node.Id()
+	resp.IP = compactAddress(packetFrom)
+
+	if iField, exist = addDict["id"]; !exist {
+		return nil, NewKRPCError(ERROR_PROTOCOL, "missing id field")
+	}
+	if nodeId, typeOk = iField.(string); !typeOk {
+		return nil, NewKRPCError(ERROR_PROTOCOL, "id type invalid")
+	}
 
 	if iField, exist = addDict["info_hash"]; !exist {
-		return nil, errors.New("missing info_hash field")
+		return nil, NewKRPCError(ERROR_PROTOCOL, "missing info_hash field")
 	}
 	if infoHash, typeOk = iField.(string); !typeOk {
-		return nil, errors.New("info_hash type invalid")
+		return nil, NewKRPCError(ERROR_PROTOCOL, "info_hash type invalid")
 	}
 
 	if iField, exist = addDict["token"]; !exist {
-		return nil, errors.New("missing token field")
+		return nil, NewKRPCError(ERROR_PROTOCOL, "missing token field")
 	}
 	if token, typeOk = iField.(string); !typeOk {
-		return nil, errors.New("token type invalid")
+		return nil, NewKRPCError(ERROR_PROTOCOL, "token type invalid")
 	}
 
 	if iField, exist = addDict["implied_port"]; exist {
 		if impliedPort, typeOk = iField.(int); !typeOk {
-			return nil, errors.New("implied_port type invalid")
+			return nil, NewKRPCError(ERROR_PROTOCOL, "implied_port type invalid")
+		}
+	}
+
+	if iField, exist = addDict["seed"]; exist {
+		if seed, typeOk = iField.(int); !typeOk {
+			return nil, NewKRPCError(ERROR_PROTOCOL, "seed type invalid")
 		}
 	}
 
-	// 解析port
+	// 解析port, implied_port=1时使用UDP来源端口
 	if impliedPort == 1 {
+		port = int(packetFrom.Port)
+	} else {
 		if iField, exist = addDict["port"]; !exist {
-			return nil, errors.New("missing port field")
+			return nil, NewKRPCError(ERROR_PROTOCOL, "missing port field")
 		}
 		if port, typeOk = iField.(int); !typeOk {
-			return nil, errors.New("port type invalid")
+			return nil, NewKRPCError(ERROR_PROTOCOL, "port type invalid")
 		}
-	} else {
-		port = int(packetFrom.Port)
 	}
 
 	// 校验token
-	if !GetTokenManager().ValidateToken(token) {
-		return nil, errors.New("token invalid")
+	if !node.tokenMgr.ValidateToken(token) {
+		return nil, NewKRPCError(ERROR_PROTOCOL, "token invalid")
+	}
+
+	// 保存peerinfo, 供get_peers返回, 后续用于抓取种子
+	node.peerStore.AddPeer(infoHash, formatAddress(packetFrom.IP, port), seed == 1)
+	node.HandlePeerInfo(&PeerEvent{
+		Type: PEER_EVENT_ANNOUNCE,
+		InfoHash: infoHash,
+		IP: packetFrom.IP,
+		Port: port,
+		NodeId: nodeId,
+		Source: packetFrom,
+		ImpliedPort: impliedPort == 1,
+		Seed: seed == 1,
+		Time: time.Now(),
+	})
+
+	return resp.Serialize()
+}
+
+// BEP 44: 返回保存的item, 以及距离target最近的节点和put需要的token
+func (node *DHTNode) HandleGet(transactionId string, addDict map[string]interface{},  packetFrom *net.UDPAddr) ([]byte, error)  {
+	var (
+		iField interface{}
+		target string
+		seq int = -1
+		exist bool
+		typeOk bool
+	)
+
+	resp := &GetResponse{}
+	resp.TransactionId = transactionId
+	resp.Id = node.Id()
+	resp.IP = compactAddress(packetFrom)
+	resp.Token = node.tokenMgr.GetToken()
+
+	if _, typeOk = addDict["id"].(string); !typeOk {
+		return nil, NewKRPCError(ERROR_PROTOCOL, "missing id field")
+	}
+	if iField, exist = addDict["target"]; !exist {
+		return nil, NewKRPCError(ERROR_PROTOCOL, "missing target field")
+	}
+	if target, typeOk = iField.(string); !typeOk {
+		return nil, NewKRPCError(ERROR_PROTOCOL, "target type invalid")
+	}
+	if iField, exist = addDict["seq"]; exist {
+		if seq, typeOk = iField.(int); !typeOk {
+			return nil, NewKRPCError(ERROR_PROTOCOL, "seq type invalid")
+		}
+	}
+
+	// 请求方已有不旧于seq的可变item时不再返回
+	if item := node.itemStore.Get(target); item != nil && (!item.IsMutable() || seq < 0 || item.Seq > seq) {
+		resp.Item = item
+	}
+	wantNodes, wantNodes6 := parseWant(addDict, packetFrom)
+	if wantNodes {
+		resp.Nodes = node.routingTable.ClosestNodes(target, KNODES)
+	}
+	if wantNodes6 {
+		resp.Nodes6 = node.routingTable6.ClosestNodes(target, KNODES)
 	}
+	return resp.Serialize()
+}
 
-	// 保存peerinfo, 后续用于抓取种子
-	HandlePeerInfo(infoHash, packetFrom.IP, port)
+// BEP 44: 校验token与签名后保存item
+func (node *DHTNode) HandlePut(transactionId string, addDict map[string]interface{},  packetFrom *net.UDPAddr) ([]byte, error)  {
+	var (
+		iField interface{}
+		token string
+		item = &Item{}
+		cas int = -1
+		exist bool
+		typeOk bool
+		err error
+	)
 
+	resp := &PutResponse{}
+	resp.TransactionId = transactionId
+	resp.Id = node.Id()
+	resp.IP = compactAddress(packetFrom)
+
+	if _, typeOk = addDict["id"].(string); !typeOk {
+		return nil, NewKRPCError(ERROR_PROTOCOL, "missing id field")
+	}
+	if iField, exist = addDict["token"]; !exist {
+		return nil, NewKRPCError(ERROR_PROTOCOL, "missing token field")
+	}
+	if token, typeOk = iField.(string); !typeOk {
+		return nil, NewKRPCError(ERROR_PROTOCOL, "token type invalid")
+	}
+	if item.Value, exist = addDict["v"]; !exist {
+		return nil, NewKRPCError(ERROR_PROTOCOL, "missing v field")
+	}
+
+	// 有k则是可变item, 需要seq与sig
+	if iField, exist = addDict["k"]; exist {
+		if item.Key, typeOk = iField.(string); !typeOk {
+			return nil, NewKRPCError(ERROR_PROTOCOL, "k type invalid")
+		}
+		if item.Seq, typeOk = addDict["seq"].(int); !typeOk {
+			return nil, NewKRPCError(ERROR_PROTOCOL, "missing seq field")
+		}
+		if item.Sig, typeOk = addDict["sig"].(string); !typeOk {
+			return nil, NewKRPCError(ERROR_PROTOCOL, "missing sig field")
+		}
+		if iField, exist = addDict["salt"]; exist {
+			if item.Salt, typeOk = iField.(string); !typeOk {
+				return nil, NewKRPCError(ERROR_PROTOCOL, "salt type invalid")
+			}
+		}
+		if iField, exist = addDict["cas"]; exist {
+			if cas, typeOk = iField.(int); !typeOk {
+				return nil, NewKRPCError(ERROR_PROTOCOL, "cas type invalid")
+			}
+		}
+	}
+
+	// 校验token
+	if !node.tokenMgr.ValidateToken(token) {
+		return nil, NewKRPCError(ERROR_PROTOCOL, "token invalid")
+	}
+	if err = item.Verify(); err != nil {
+		return nil, err
+	}
+	if err = node.itemStore.Put(item, cas); err != nil {
+		return nil, err
+	}
 	return resp.Serialize()
 }
 
-func HandlePeerInfo(infoHash string, ip net.IP, port int) {
-	fmt.Println( "magnet:?xt=urn:btih:" + hex.EncodeToString([]byte(infoHash)), ip, ":", port)
+// 交给节点注册的PeerSink
+func (node *DHTNode) HandlePeerInfo(event *PeerEvent) {
+	if sink := node.PeerSink(); sink != nil {
+		sink.HandlePeer(event)
+	}
 }
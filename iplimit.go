@@ -0,0 +1,134 @@
+package dht
+
+import (
+	"net"
+)
+
+// 同一IP或子网(IPv4 /24, IPv6 /64)在路由表中的节点数上限, 防止单个主机用大量ID占据路由表.
+// 小于等于0表示不限制; 回环地址不受限制, 便于本机组网测试
+type IPLimits struct {
+	BucketPerIP int // 每个桶内同一IP的节点数
+	BucketPerSubnet int // 每个桶内同一子网的节点数
+	TablePerIP int // 整个路由表内同一IP的节点数
+	TablePerSubnet int // 整个路由表内同一子网的节点数
+}
+
+// 默认限制
+var DefaultIPLimits = IPLimits{
+	BucketPerIP: 1,
+	BucketPerSubnet: 2,
+	TablePerIP: 4,
+	TablePerSubnet: 16,
+}
+
+// 统计路由表中各IP与子网的非bad节点数, 并按地址索引桶内节点与候选节点, 由路由表的锁保护
+type ipLimiter struct {
+	limits IPLimits
+	ips map[string]int
+	subnets map[string]int
+	addresses map[string]NodeID // 地址 -> 节点ID, 用于按地址查找节点
+}
+
+func newIPLimiter() *ipLimiter {
+	return &ipLimiter{ips: make(map[string]int), subnets: make(map[string]int), addresses: make(map[string]NodeID)}
+}
+
+// 地址对应的IP与子网, 不受限制的地址返回空串
+func addressKeys(address string) (ip string, subnet string) {
+	parsed := addressIP(address)
+	if parsed == nil || parsed.IsLoopback() {
+		return
+	}
+	if ip4 := parsed.To4(); ip4 != nil {
+		return ip4.String(), ip4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+	return parsed.String(), parsed.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
+
+// 地址中的IP, 无法解析时返回nil
+func addressIP(address string) net.IP {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+func withinLimit(limit int, count int) bool {
+	return limit <= 0 || count < limit
+}
+
+// 节点能否以address加入bucket; 已在桶内的同一ID不计入
+func (limiter *ipLimiter) allow(bucket *Bucket, id NodeID, address string) bool {
+	ip, subnet := addressKeys(address)
+	if ip == "" {
+		return true
+	}
+
+	var (
+		bucketIPs, bucketSubnets int
+		tableIPs = limiter.ips[ip]
+		tableSubnets = limiter.subnets[subnet]
+	)
+	for nodeId, node := range bucket.nodes {
+		if node.status == NODE_STATUS_BAD { // 不计入统计, 会被淘汰
+			continue
+		}
+		if nodeId == id { // 刷新已有节点
+			if node.ip == ip {
+				tableIPs--
+			}
+			if node.subnet == subnet {
+				tableSubnets--
+			}
+			continue
+		}
+		if node.ip == ip {
+			bucketIPs++
+		}
+		if node.subnet == subnet {
+			bucketSubnets++
+		}
+	}
+	return withinLimit(limiter.limits.BucketPerIP, bucketIPs) &&
+		withinLimit(limiter.limits.BucketPerSubnet, bucketSubnets) &&
+		withinLimit(limiter.limits.TablePerIP, tableIPs) &&
+		withinLimit(limiter.limits.TablePerSubnet, tableSubnets)
+}
+
+func (limiter *ipLimiter) add(node *Node) {
+	if node.ip != "" {
+		limiter.ips[node.ip]++
+		limiter.subnets[node.subnet]++
+	}
+}
+
+func (limiter *ipLimiter) remove(node *Node) {
+	if node.ip == "" {
+		return
+	}
+	if limiter.ips[node.ip]--; limiter.ips[node.ip] <= 0 {
+		delete(limiter.ips, node.ip)
+	}
+	if limiter.subnets[node.subnet]--; limiter.subnets[node.subnet] <= 0 {
+		delete(limiter.subnets, node.subnet)
+	}
+}
+
+func (limiter *ipLimiter) index(address string, id NodeID) {
+	limiter.addresses[address] = id
+}
+
+// 地址已被其他节点占用时保留原索引
+func (limiter *ipLimiter) unindex(address string, id NodeID) {
+	if limiter.addresses[address] == id {
+		delete(limiter.addresses, address)
+	}
+}
+
+// 调整限制, 已在路由表中的节点不受影响
+func (rt *RoutingTable) SetIPLimits(limits IPLimits) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	rt.limiter.limits = limits
+}
@@ -0,0 +1,121 @@
+package dht
+
+import (
+	"crypto/ed25519"
+	"crypto/sha1"
+	"errors"
+	"strconv"
+)
+
+const (
+	MAX_ITEM_VALUE_SIZE = 1000 // bencode编码后v的上限
+	MAX_ITEM_SALT_SIZE = 64 // salt的上限
+)
+
+var ErrItemNotFound = errors.New("item not found")
+
+// BEP 44保存在DHT中的数据. Key为空是不可变item, target为SHA1(bencode(v));
+// 否则是以ed25519公钥签名的可变item, target为SHA1(k + salt)
+type Item struct {
+	Value interface{} // 任意bencode值
+	Key string // 32字节ed25519公钥
+	Salt string // 同一公钥下区分多个item
+	Seq int // 序号, 只能递增
+	Sig string // 64字节签名
+}
+
+func NewImmutableItem(value interface{}) *Item {
+	return &Item{Value: value}
+}
+
+// 用私钥签名的可变item
+func NewMutableItem(value interface{}, privateKey ed25519.PrivateKey, salt string, seq int) (item *Item, err error) {
+	item = &Item{
+		Value: value,
+		Key: string(privateKey.Public().(ed25519.PublicKey)),
+		Salt: salt,
+		Seq: seq,
+	}
+	if err = item.Sign(privateKey); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func ImmutableTarget(value interface{}) (string, error) {
+	encoded, err := Encode(value)
+	if err != nil {
+		return "", err
+	}
+	sum := sha1.Sum(encoded)
+	return string(sum[:]), nil
+}
+
+func MutableTarget(key string, salt string) string {
+	sum := sha1.Sum([]byte(key + salt))
+	return string(sum[:])
+}
+
+func (item *Item) IsMutable() bool {
+	return len(item.Key) != 0
+}
+
+func (item *Item) Target() (string, error) {
+	if item.IsMutable() {
+		return MutableTarget(item.Key, item.Salt), nil
+	}
+	return ImmutableTarget(item.Value)
+}
+
+// 签名内容: [4:salt<salt长度>:<salt>]3:seqi<seq>e1:v<bencode(v)>
+func signaturePayload(salt string, seq int, value interface{}) (payload []byte, err error) {
+	var encoded []byte
+	if encoded, err = Encode(value); err != nil {
+		return
+	}
+	if len(salt) != 0 {
+		payload = append(payload, "4:salt" + strconv.Itoa(len(salt)) + ":" + salt...)
+	}
+	payload = append(payload, "3:seqi" + strconv.Itoa(seq) + "e1:v"...)
+	return append(payload, encoded...), nil
+}
+
+func (item *Item) Sign(privateKey ed25519.PrivateKey) error {
+	payload, err := signaturePayload(item.Salt, item.Seq, item.Value)
+	if err != nil {
+		return err
+	}
+	item.Sig = string(ed25519.Sign(privateKey, payload))
+	return nil
+}
+
+// 校验大小与签名, 错误为对应错误码的*KRPCError
+func (item *Item) Verify() error {
+	var (
+		encoded []byte
+		payload []byte
+		err error
+	)
+	if encoded, err = Encode(item.Value); err != nil {
+		return NewKRPCError(ERROR_PROTOCOL, "v type invalid")
+	}
+	if len(encoded) > MAX_ITEM_VALUE_SIZE {
+		return NewKRPCError(ERROR_MESSAGE_TOO_BIG, "message (v field) too big")
+	}
+	if len(item.Salt) > MAX_ITEM_SALT_SIZE {
+		return NewKRPCError(ERROR_SALT_TOO_BIG, "salt (salt field) too big")
+	}
+	if !item.IsMutable() {
+		return nil
+	}
+	if len(item.Key) != ed25519.PublicKeySize || len(item.Sig) != ed25519.SignatureSize {
+		return NewKRPCError(ERROR_INVALID_SIGNATURE, "invalid signature")
+	}
+	if payload, err = signaturePayload(item.Salt, item.Seq, item.Value); err != nil {
+		return NewKRPCError(ERROR_PROTOCOL, "v type invalid")
+	}
+	if !ed25519.Verify(ed25519.PublicKey(item.Key), payload, []byte(item.Sig)) {
+		return NewKRPCError(ERROR_INVALID_SIGNATURE, "invalid signature")
+	}
+	return nil
+}
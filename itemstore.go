@@ -0,0 +1,127 @@
+package dht
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+// 内存中保存put的item(BEP 44), 按target索引, 过期前需要重新put刷新
+type ItemStore struct {
+	mutex sync.Mutex
+	items map[string]*storedItem // target -> item
+
+	expire time.Duration // item有效期
+	maxItems int // item总数上限
+
+	closeOnce sync.Once
+	closeNotify chan byte // 通知停止清理
+}
+
+type storedItem struct {
+	item *Item
+	expireTime int64
+}
+
+func NewItemStore(expire time.Duration, maxItems int) (store *ItemStore) {
+	store = &ItemStore{}
+	store.items = make(map[string]*storedItem)
+	store.expire = expire
+	store.maxItems = maxItems
+	store.closeNotify = make(chan byte)
+	go store.cleanLoop()
+	return store
+}
+
+// 定期清理过期的item
+func (store *ItemStore) cleanLoop() {
+	ticker := time.NewTicker(time.Duration(1) * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <- ticker.C:
+			store.clean(time.Now().Unix())
+		case <- store.closeNotify:
+			return
+		}
+	}
+}
+
+func (store *ItemStore) clean(now int64) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for target, stored := range store.items {
+		if stored.expireTime <= now {
+			delete(store.items, target)
+		}
+	}
+}
+
+// 停止清理
+func (store *ItemStore) Close() {
+	store.closeOnce.Do(func() {
+		close(store.closeNotify)
+	})
+}
+
+// 保存已校验过签名的item. cas不小于0时, 必须与当前保存的seq一致;
+// 可变item的seq不能小于当前seq, seq相同时v也必须相同(只刷新过期时间)
+func (store *ItemStore) Put(item *Item, cas int) error {
+	var (
+		target string
+		stored *storedItem
+		exist bool
+		err error
+	)
+	if target, err = item.Target(); err != nil {
+		return NewKRPCError(ERROR_PROTOCOL, "v type invalid")
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	expireTime := time.Now().Add(store.expire).Unix()
+
+	if stored, exist = store.items[target]; exist && item.IsMutable() {
+		if cas >= 0 && cas != stored.item.Seq {
+			return NewKRPCError(ERROR_CAS_MISMATCH, "the CAS hash mismatched, re-read value and try again")
+		}
+		if item.Seq < stored.item.Seq {
+			return NewKRPCError(ERROR_SEQ_TOO_SMALL, "sequence number less than current")
+		}
+		if item.Seq == stored.item.Seq && !sameValue(item.Value, stored.item.Value) {
+			return NewKRPCError(ERROR_SEQ_TOO_SMALL, "sequence number less than current")
+		}
+	}
+	if !exist && len(store.items) >= store.maxItems {
+		return NewKRPCError(ERROR_GENERIC, "item storage full")
+	}
+	store.items[target] = &storedItem{item: item, expireTime: expireTime}
+	return nil
+}
+
+func sameValue(left interface{}, right interface{}) bool {
+	leftBytes, leftErr := Encode(left)
+	rightBytes, rightErr := Encode(right)
+	return leftErr == nil && rightErr == nil && bytes.Equal(leftBytes, rightBytes)
+}
+
+// 未过期的item, 不存在返回nil
+func (store *ItemStore) Get(target string) *Item {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if stored, exist := store.items[target]; exist && stored.expireTime > time.Now().Unix() {
+		return stored.item
+	}
+	return nil
+}
+
+// 保存的item数量
+func (store *ItemStore) Size() int {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return len(store.items)
+}
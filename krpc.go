@@ -5,8 +5,7 @@ import (
 	"sync"
 	"context"
 	"time"
-	"errors"
-	"runtime"
+	"strconv"
 )
 
 type KRPCContext struct {
@@ -20,9 +19,18 @@ type KRPCContext struct {
 	resDict map[string]interface{} // r字典
 	responseFrom *net.UDPAddr // 发送应答的地址
 
+	err error // 请求未能完成的原因(例如KRPC已关闭)
 	finishNotify chan byte // 收到应答后唤醒
 }
 
+// 远端返回错误应答时, 转换为*KRPCError
+func (ctx *KRPCContext) remoteError() error {
+	if ctx.errCode != 0 {
+		return NewKRPCError(ctx.errCode, ctx.errMsg)
+	}
+	return nil
+}
+
 type KRPCResponse struct {
 	encoded []byte // 序列化应答
 	responseTo *net.UDPAddr // 回复地址
@@ -44,6 +52,18 @@ type KRPC struct {
 
 	procQueue chan *KRPCPacket // 处理外来包队列
 	procPending chan byte // 请求处理堆积控制
+
+	node *DHTNode // 所属节点
+	network string // udp4, udp6, udp(双栈)
+	timeout time.Duration // 默认RPC超时
+	readOnly bool // BEP 43只读模式
+
+	closed bool // 是否已关闭
+	stopNotify chan byte // 通知停止收包
+	closeNotify chan byte // 通知停止发包
+	recvWait sync.WaitGroup // 等待ReadLoop, ProcLoop退出
+	handleWait sync.WaitGroup // 等待处理中的请求完成
+	sendWait sync.WaitGroup // 等待SendLoop退出
 }
 
 func (krpc *KRPC)HandleResponse(transactionId string, benDict map[string]interface{},  packetFrom *net.UDPAddr) {
@@ -63,17 +83,12 @@ func (krpc *KRPC)HandleResponse(transactionId string, benDict map[string]interfa
 	}
 
 	// 寻找请求上下文
-	{
-		krpc.mutex.Lock()
-		if ctx, exist = krpc.reqContext[transactionId]; exist {
-			delete(krpc.reqContext, transactionId)
-		}
-		krpc.mutex.Unlock()
-	}
+	ctx = krpc.takeContext(transactionId, packetFrom)
 	// 唤醒调用者进一步处理
 	if ctx != nil {
 		ctx.resDict = resDict
 		ctx.responseFrom = packetFrom
+		krpc.voteExternalIP(ctx, benDict)
 		ctx.finishNotify <- 1
 	}
 }
@@ -107,23 +122,37 @@ func (krpc *KRPC)HandleError(transactionId string, benDict map[string]interface{
 	}
 
 	// 寻找请求上下文
-	{
-		krpc.mutex.Lock()
-		if ctx, exist = krpc.reqContext[transactionId]; exist {
-			delete(krpc.reqContext, transactionId)
-		}
-		krpc.mutex.Unlock()
-	}
+	ctx = krpc.takeContext(transactionId, packetFrom)
 	// 唤醒调用者进一步处理
 	if ctx != nil {
 		ctx.errCode = errCode
 		ctx.errMsg = errMsg
 		ctx.resDict = nil
 		ctx.responseFrom = packetFrom
+		krpc.voteExternalIP(ctx, benDict)
 		ctx.finishNotify <- 1
 	}
 }
 
+// 取出请求上下文; 应答必须来自请求的目标地址, 否则视为伪造而丢弃, 请求继续等待真正的应答
+func (krpc *KRPC) takeContext(transactionId string, packetFrom *net.UDPAddr) (ctx *KRPCContext) {
+	var exist bool
+
+	krpc.mutex.Lock()
+	defer krpc.mutex.Unlock()
+
+	if ctx, exist = krpc.reqContext[transactionId]; !exist || !sameAddress(ctx.requestTo, packetFrom) {
+		return nil
+	}
+	delete(krpc.reqContext, transactionId)
+	return ctx
+}
+
+// IP与端口都相同
+func sameAddress(left *net.UDPAddr, right *net.UDPAddr) bool {
+	return left.IP.Equal(right.IP) && left.Port == right.Port
+}
+
 func (krpc *KRPC)HandleRequest(transactionId string, benDict map[string]interface{},  packetFrom *net.UDPAddr) {
 	var (
 		iField interface{}
@@ -135,18 +164,23 @@ func (krpc *KRPC)HandleRequest(transactionId string, benDict map[string]interfac
 		err error
 	)
 
-	if iField, exist = benDict["q"]; !exist {
+	// 只读模式不处理请求, 包括格式错误的请求
+	if krpc.readOnly {
 		return
 	}
+
+	if iField, exist = benDict["q"]; !exist {
+		goto INVALID
+	}
 	if method, typeOk = iField.(string); !typeOk {
-		return
+		goto INVALID
 	}
 
 	if iField, exist = benDict["a"]; !exist {
-		return
+		goto INVALID
 	}
 	if addDict, typeOk = iField.(map[string]interface{}); !typeOk {
-		return
+		goto INVALID
 	}
 
 	select {
@@ -155,24 +189,75 @@ func (krpc *KRPC)HandleRequest(transactionId string, benDict map[string]interfac
 			return
 	}
 	// 并发协程处理
+	krpc.handleWait.Add(1)
 	go func() {
+		defer krpc.handleWait.Done()
+
+		// 请求方是活跃的, 但只读节点(ro=1)不会应答请求, 不加入路由表 (BEP 43)
+		if ro, _ := benDict["ro"].(int); ro != 1 {
+			krpc.node.ActiveNode(addDict, packetFrom)
+		}
+
 		if method == "ping" {
-			respBytes, err = HandlePing(transactionId, addDict, packetFrom)
+			respBytes, err = krpc.node.HandlePing(transactionId, addDict, packetFrom)
 		} else if method == "find_node" {
-			respBytes, err = HandleFindNode(transactionId, addDict, packetFrom)
+			respBytes, err = krpc.node.HandleFindNode(transactionId, addDict, packetFrom)
 		} else if method == "get_peers" {
-			respBytes, err = HandleGetPeer(transactionId, addDict, packetFrom)
+			respBytes, err = krpc.node.HandleGetPeer(transactionId, addDict, packetFrom)
 		} else if method == "announce_peer" {
-			respBytes, err = HandleAnnouncePeer(transactionId, addDict, packetFrom)
+			respBytes, err = krpc.node.HandleAnnouncePeer(transactionId, addDict, packetFrom)
+		} else if method == "get" {
+			respBytes, err = krpc.node.HandleGet(transactionId, addDict, packetFrom)
+		} else if method == "put" {
+			respBytes, err = krpc.node.HandlePut(transactionId, addDict, packetFrom)
+		} else if method == "sample_infohashes" {
+			respBytes, err = krpc.node.HandleSampleInfohashes(transactionId, addDict, packetFrom)
 		} else {
-			goto END
+			err = NewKRPCError(ERROR_METHOD_UNKNOWN, "method unknown")
+		}
+		// 处理失败回复错误应答
+		if err != nil {
+			errResp := NewErrorResponse(transactionId, err)
+			errResp.IP = compactAddress(packetFrom)
+			respBytes, err = errResp.Serialize()
 		}
 		if err == nil {
-			krpc.resQueue <- &KRPCResponse{encoded: respBytes, responseTo: packetFrom}
+			select {
+			case krpc.resQueue <- &KRPCResponse{encoded: respBytes, responseTo: packetFrom}:
+			case <- krpc.closeNotify: // 已关闭, 丢弃应答
+			}
 		}
-		END:
 		<- krpc.procPending // 处理完释放计数
 	}()
+	return
+
+INVALID:
+	krpc.replyError(transactionId, NewKRPCError(ERROR_PROTOCOL, "invalid query"), packetFrom)
+}
+
+// 统计应答中的ip字段 (BEP 42). ctx由takeContext取出, 应答已确认来自请求的目标地址,
+// 猜中请求ID但来自其他地址的伪造包不会到达这里
+func (krpc *KRPC) voteExternalIP(ctx *KRPCContext, benDict map[string]interface{}) {
+	if compactIP, typeOk := benDict["ip"].(string); typeOk {
+		krpc.node.voteExternalIP(compactIP, ctx.responseFrom)
+	}
+}
+
+// 回复错误应答, 发送队列满则丢弃
+func (krpc *KRPC) replyError(transactionId string, krpcErr *KRPCError, packetFrom *net.UDPAddr) {
+	var (
+		respBytes []byte
+		err error
+	)
+	errResp := NewErrorResponse(transactionId, krpcErr)
+	errResp.IP = compactAddress(packetFrom)
+	if respBytes, err = errResp.Serialize(); err != nil {
+		return
+	}
+	select {
+	case krpc.resQueue <- &KRPCResponse{encoded: respBytes, responseTo: packetFrom}:
+	default:
+	}
 }
 
 func (krpc *KRPC)HandlePacket(data []byte, packetFrom *net.UDPAddr) {
@@ -233,9 +318,15 @@ func (krpc *KRPC)ProcLoop() {
 	var (
 		packet *KRPCPacket
 	)
+	defer krpc.recvWait.Done()
+
 	for {
-		packet = <- krpc.procQueue
-		krpc.HandlePacket(packet.encoded, packet.packetFrom)
+		select {
+		case packet = <- krpc.procQueue:
+			krpc.HandlePacket(packet.encoded, packet.packetFrom)
+		case <- krpc.stopNotify:
+			return
+		}
 	}
 }
 
@@ -247,9 +338,16 @@ func (krpc *KRPC)ReadLoop() {
 		buffer []byte = make([]byte, 10000)
 		bufSize int
 	)
+	defer krpc.recvWait.Done()
+
 	for {
 		if bufSize, packetFrom, err = krpc.conn.ReadFromUDP(buffer); err != nil || bufSize == 0 {
-			continue
+			select {
+			case <- krpc.stopNotify:
+				return
+			default:
+				continue
+			}
 		}
 
 		data := make([]byte, bufSize)
@@ -257,7 +355,11 @@ func (krpc *KRPC)ReadLoop() {
 
 		packet := &KRPCPacket{encoded: data, packetFrom: packetFrom}
 
-		krpc.procQueue <- packet
+		select {
+		case krpc.procQueue <- packet:
+		case <- krpc.stopNotify:
+			return
+		}
 	}
 }
 
@@ -266,42 +368,180 @@ func (krpc *KRPC) SendLoop() {
 		ctx *KRPCContext
 		resp *KRPCResponse
 	)
+	defer krpc.sendWait.Done()
+
 	for {
 		select {
 		case ctx = <-krpc.reqQueue:
 			krpc.conn.WriteToUDP(ctx.encoded, ctx.requestTo)
 		case resp = <- krpc.resQueue:
 			krpc.conn.WriteToUDP(resp.encoded, resp.responseTo)
+		case <- krpc.closeNotify:
+			// 发完剩余的应答, 未发出的请求随后以ErrClosed失败
+			for {
+				select {
+				case resp = <- krpc.resQueue:
+					krpc.conn.WriteToUDP(resp.encoded, resp.responseTo)
+				case <- krpc.reqQueue:
+				default:
+					return
+				}
+			}
 		}
 	}
 }
 
-func CreateKPRC() (krpc *KRPC, err error){
+// 优雅关闭: 停止所属节点的维护协程, 停止收包, 等待处理中的请求完成(直到ctx结束), 发完剩余应答,
+// 关闭socket, 所有等待应答的请求以ErrClosed失败, 最后释放节点的peer存储与token
+func (krpc *KRPC) Shutdown(ctx context.Context) (err error) {
+	var (
+		handleDone = make(chan byte)
+	)
+
+	{
+		krpc.mutex.Lock()
+		if krpc.closed {
+			krpc.mutex.Unlock()
+			return ErrClosed
+		}
+		krpc.closed = true
+		krpc.mutex.Unlock()
+	}
+	krpc.node.stopMaintain()
+
+	// 停止收包
+	close(krpc.stopNotify)
+	krpc.conn.SetReadDeadline(time.Now()) // 唤醒阻塞中的ReadFromUDP
+	krpc.recvWait.Wait()
+
+	// 等待处理中的请求
+	go func() {
+		krpc.handleWait.Wait()
+		close(handleDone)
+	}()
+	select {
+	case <- handleDone:
+	case <- ctx.Done():
+		err = ctx.Err()
+	}
+
+	// 停止发包, 释放端口
+	close(krpc.closeNotify)
+	krpc.sendWait.Wait()
+	krpc.conn.Close()
+
+	// 唤醒所有等待应答的调用者
+	{
+		krpc.mutex.Lock()
+		for transactionId, reqCtx := range krpc.reqContext {
+			delete(krpc.reqContext, transactionId)
+			reqCtx.err = ErrClosed
+			reqCtx.finishNotify <- 1
+		}
+		krpc.mutex.Unlock()
+	}
+	krpc.node.releaseState()
+	return
+}
+
+// 立即关闭, 不等待处理中的请求
+func (krpc *KRPC) Close() error {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	cancelFunc()
+	if err := krpc.Shutdown(ctx); err != nil && err != context.Canceled {
+		return err
+	}
+	return nil
+}
+
+func newKRPC(node *DHTNode, options *Options) (krpc *KRPC, err error) {
+	var (
+		addr *net.UDPAddr
+	)
+
 	krpc = &KRPC{}
-	addr := net.UDPAddr{net.IPv4(0, 0, 0,0), 6881, ""}
-	if krpc.conn, err = net.ListenUDP("udp4", &addr); err != nil {
+	krpc.node = node
+	krpc.network = options.Network
+	krpc.timeout = options.Timeout
+	krpc.readOnly = options.ReadOnly
+
+	if addr, err = net.ResolveUDPAddr(options.Network, net.JoinHostPort(options.ListenAddr, strconv.Itoa(options.ListenPort))); err != nil {
+		return nil, err
+	}
+	if krpc.conn, err = net.ListenUDP(options.Network, addr); err != nil {
 		return nil, err
 	}
 	krpc.reqContext = make(map[string]*KRPCContext)
-	krpc.reqQueue = make(chan *KRPCContext, 100000)
-	krpc.resQueue = make(chan *KRPCResponse, 100000)
-	krpc.procQueue = make(chan *KRPCPacket, 100000)
-	krpc.procPending = make(chan byte, 100000)
+	krpc.reqQueue = make(chan *KRPCContext, options.ReqQueueSize)
+	krpc.resQueue = make(chan *KRPCResponse, options.ResQueueSize)
+	krpc.procQueue = make(chan *KRPCPacket, options.ProcQueueSize)
+	krpc.procPending = make(chan byte, options.MaxPending)
+	krpc.stopNotify = make(chan byte)
+	krpc.closeNotify = make(chan byte)
+
+	krpc.sendWait.Add(1)
 	go krpc.SendLoop()
+	krpc.recvWait.Add(1 + options.ProcWorkers)
 	go krpc.ReadLoop()
-	for i := 0; i < runtime.NumCPU(); i++ {
+	for i := 0; i < options.ProcWorkers; i++ {
 		go krpc.ProcLoop()
 	}
 	return krpc, nil
 }
 
+// 按配置创建一个独立节点, 返回其KRPC
+func NewKRPC(options *Options) (krpc *KRPC, err error) {
+	var (
+		node *DHTNode
+	)
+	if node, err = NewNode(options); err != nil {
+		return nil, err
+	}
+	return node.krpc, nil
+}
+
+// 监听0.0.0.0:6881, 使用进程级单例的默认节点
+func CreateKPRC() (krpc *KRPC, err error){
+	var (
+		node *DHTNode
+	)
+	if node, err = newDefaultNode(DefaultOptions()); err != nil {
+		return nil, err
+	}
+	return node.krpc, nil
+}
+
+// 所属节点
+func (krpc *KRPC) Node() *DHTNode {
+	return krpc.node
+}
+
+// 本节点ID
+func (krpc *KRPC) NodeId() string {
+	return krpc.node.Id()
+}
+
+// 请求中携带的节点ID, 未填写则使用本节点ID
+func (krpc *KRPC) requestId(id string) string {
+	if len(id) == 0 {
+		return krpc.node.Id()
+	}
+	return id
+}
+
+// 实际监听的地址
+func (krpc *KRPC) LocalAddr() *net.UDPAddr {
+	return krpc.conn.LocalAddr().(*net.UDPAddr)
+}
+
 func (krpc *KRPC) BurstRequest(userCtx context.Context, transactionId string, request interface{}, encoded []byte, address string) (ctxt *KRPCContext, err error) {
 	var (
 		requestTo *net.UDPAddr
 		isTimeout bool = false
+		queued bool = false
 	)
 	// 域名解析
-	if requestTo, err = net.ResolveUDPAddr("udp4", address); err != nil {
+	if requestTo, err = net.ResolveUDPAddr(krpc.network, address); err != nil {
 		return
 	}
 	// 生成调用上下文
@@ -315,14 +555,24 @@ func (krpc *KRPC) BurstRequest(userCtx context.Context, transactionId string, re
 	// 注册调用
 	{
 		krpc.mutex.Lock()
+		if krpc.closed {
+			krpc.mutex.Unlock()
+			return nil, ErrClosed
+		}
+		if _, exist := krpc.reqContext[transactionId]; exist { // 随机ID碰撞, 不能覆盖在途的请求
+			krpc.mutex.Unlock()
+			return nil, ErrTransactionIdInUse
+		}
 		krpc.reqContext[transactionId] = ctx
 		krpc.mutex.Unlock()
 	}
 	// 启动RPC超时
-	timeoutCtx, cancelFunc := context.WithTimeout(userCtx, time.Duration(1) * time.Second)
+	timeoutCtx, cancelFunc := context.WithTimeout(userCtx, krpc.timeout)
 	defer cancelFunc()
 	select {
 	case krpc.reqQueue <- ctx:  // 排队请求
+		queued = true
+	case <- krpc.closeNotify: // 已关闭, 等待Shutdown唤醒
 	case <- timeoutCtx.Done(): // 等待超时
 		isTimeout = true
 	}
@@ -342,7 +592,22 @@ func (krpc *KRPC) BurstRequest(userCtx context.Context, transactionId string, re
 			}
 			krpc.mutex.Unlock()
 		}
-		return nil, errors.New("request timeout")
+		// 调用方主动取消(或调用方自己的期限到了)不是RPC超时, 也不算对方失败
+		if err = userCtx.Err(); err != nil {
+			return nil, err
+		}
+		// 发送队列满而没有发出的请求, 超时与对方无关
+		if queued {
+			krpc.node.FailNode(requestTo)
+		}
+		return nil, ErrTimeout
+	}
+	if ctx.err != nil {
+		return nil, ctx.err
+	}
+	// 应答方是可达的, 加入或刷新路由表
+	if ctx.errCode == 0 {
+		krpc.node.ActiveNode(ctx.resDict, ctx.responseFrom)
 	}
 	return ctx, nil
 }
@@ -358,8 +623,11 @@ func (krpc *KRPC) Ping(userCtx context.Context, request *PingRequest, address st
 	protobuf["t"] = request.TransactionId
 	protobuf["y"] = request.Type
 	protobuf["q"] = request.Method
+	if krpc.readOnly {
+		protobuf["ro"] = 1
+	}
 	protobuf["a"] = map[string]interface{}{
-		"id": MyNodeId(),
+		"id": krpc.requestId(request.Id),
 	}
 	if bytes, err = Encode(protobuf); err != nil {
 		return
@@ -368,8 +636,8 @@ func (krpc *KRPC) Ping(userCtx context.Context, request *PingRequest, address st
 	if ctx, err = krpc.BurstRequest(userCtx, request.TransactionId, request, bytes, address); err != nil {
 		return
 	}
-	if ctx.errCode != 0 {
-		return nil, errors.New(ctx.errMsg)
+	if err = ctx.remoteError(); err != nil {
+		return
 	}
 	response, err = UnserializePingResponse(ctx.transactionId, ctx.resDict)
 	return
@@ -379,6 +647,7 @@ func (krpc *KRPC) FindNode(userCtx context.Context, request *FindNodeRequest, ad
 	var (
 		ctx *KRPCContext
 		bytes []byte
+		addition map[string]interface{}
 	)
 
 	// 序列化
@@ -386,10 +655,17 @@ func (krpc *KRPC) FindNode(userCtx context.Context, request *FindNodeRequest, ad
 	protobuf["t"] = request.TransactionId
 	protobuf["y"] = request.Type
 	protobuf["q"] = request.Method
-	protobuf["a"] = map[string]interface{}{
-		"id": MyNodeId(),
+	if krpc.readOnly {
+		protobuf["ro"] = 1
+	}
+	addition = map[string]interface{}{
+		"id": krpc.requestId(request.Id),
 		"target": request.Target,
 	}
+	if len(request.Want) != 0 {
+		addition["want"] = serializeWant(request.Want)
+	}
+	protobuf["a"] = addition
 	if bytes, err = Encode(protobuf); err != nil {
 		return
 	}
@@ -397,8 +673,8 @@ func (krpc *KRPC) FindNode(userCtx context.Context, request *FindNodeRequest, ad
 	if ctx, err = krpc.BurstRequest(userCtx, request.TransactionId, request, bytes, address); err != nil {
 		return
 	}
-	if ctx.errCode != 0 {
-		return nil, errors.New(ctx.errMsg)
+	if err = ctx.remoteError(); err != nil {
+		return
 	}
 	response, err = UnserializeFindNodeResponse(ctx.transactionId, ctx.resDict)
 	return
@@ -408,6 +684,7 @@ func (krpc *KRPC) GetPeers(userCtx context.Context, request *GetPeersRequest, ad
 	var (
 		ctx *KRPCContext
 		bytes []byte
+		addition map[string]interface{}
 	)
 
 	// 序列化
@@ -415,16 +692,32 @@ func (krpc *KRPC) GetPeers(userCtx context.Context, request *GetPeersRequest, ad
 	protobuf["t"] = request.TransactionId
 	protobuf["y"] = request.Type
 	protobuf["q"] = request.Method
-	protobuf["a"] = map[string]interface{}{
-		"id": MyNodeId(),
+	if krpc.readOnly {
+		protobuf["ro"] = 1
+	}
+	addition = map[string]interface{}{
+		"id": krpc.requestId(request.Id),
 		"info_hash": request.InfoHash,
 	}
+	if len(request.Want) != 0 {
+		addition["want"] = serializeWant(request.Want)
+	}
+	if request.Scrape == 1 {
+		addition["scrape"] = 1
+	}
+	if request.NoSeed == 1 {
+		addition["noseed"] = 1
+	}
+	protobuf["a"] = addition
 	if bytes, err = Encode(protobuf); err != nil {
 		return
 	}
 	if ctx, err = krpc.BurstRequest(userCtx, request.TransactionId, request, bytes, address); err != nil {
 		return
 	}
+	if err = ctx.remoteError(); err != nil {
+		return
+	}
 	response, err = UnserializeGetPeersResponse(ctx.transactionId, ctx.resDict)
 	return
 }
@@ -441,17 +734,21 @@ func (krpc *KRPC) AnnouncePeer(userCtx context.Context, request *AnnouncePeerReq
 	protobuf["t"] = request.TransactionId
 	protobuf["y"] = request.Type
 	protobuf["q"] = request.Method
+	if krpc.readOnly {
+		protobuf["ro"] = 1
+	}
 	addition = map[string]interface{}{
-		"id": MyNodeId(),
+		"id": krpc.requestId(request.Id),
 		"implied_port": request.ImpliedPort,
 		"info_hash": request.InfoHash,
 	}
-	if request.ImpliedPort != 0 {
-		addition["port"] = request.Port
-	}
+	addition["port"] = request.Port
 	if len(request.Token) != 0 {
 		addition["token"] = request.Token
 	}
+	if request.Seed == 1 {
+		addition["seed"] = 1
+	}
 	protobuf["a"] = addition
 	if bytes, err = Encode(protobuf); err != nil {
 		return
@@ -459,6 +756,148 @@ func (krpc *KRPC) AnnouncePeer(userCtx context.Context, request *AnnouncePeerReq
 	if ctx, err = krpc.BurstRequest(userCtx, request.TransactionId, request, bytes, address); err != nil {
 		return
 	}
+	if err = ctx.remoteError(); err != nil {
+		return
+	}
 	response, err = UnserializeAnnouncePeerResponse(ctx.transactionId, ctx.resDict)
 	return
-}
\ No newline at end of file
+}
+
+// BEP 44: 获取target对应的item, 同时得到put所需的token
+func (krpc *KRPC) Get(userCtx context.Context, request *GetRequest, address string) (response *GetResponse, err error) {
+	var (
+		ctx *KRPCContext
+		bytes []byte
+		addition map[string]interface{}
+	)
+
+	// 序列化
+	protobuf := map[string]interface{}{}
+	protobuf["t"] = request.TransactionId
+	protobuf["y"] = request.Type
+	protobuf["q"] = request.Method
+	if krpc.readOnly {
+		protobuf["ro"] = 1
+	}
+	addition = map[string]interface{}{
+		"id": krpc.requestId(request.Id),
+		"target": request.Target,
+	}
+	if request.Seq >= 0 {
+		addition["seq"] = request.Seq
+	}
+	if len(request.Want) != 0 {
+		addition["want"] = serializeWant(request.Want)
+	}
+	protobuf["a"] = addition
+	if bytes, err = Encode(protobuf); err != nil {
+		return
+	}
+	if ctx, err = krpc.BurstRequest(userCtx, request.TransactionId, request, bytes, address); err != nil {
+		return
+	}
+	if err = ctx.remoteError(); err != nil {
+		return
+	}
+	response, err = UnserializeGetResponse(ctx.transactionId, ctx.resDict)
+	return
+}
+
+// BEP 44: 保存item, token来自对方的get应答
+func (krpc *KRPC) Put(userCtx context.Context, request *PutRequest, address string) (response *PutResponse, err error) {
+	var (
+		ctx *KRPCContext
+		bytes []byte
+		addition map[string]interface{}
+	)
+
+	// 序列化
+	protobuf := map[string]interface{}{}
+	protobuf["t"] = request.TransactionId
+	protobuf["y"] = request.Type
+	protobuf["q"] = request.Method
+	if krpc.readOnly {
+		protobuf["ro"] = 1
+	}
+	addition = map[string]interface{}{
+		"id": krpc.requestId(request.Id),
+		"token": request.Token,
+		"v": request.Item.Value,
+	}
+	if request.Item.IsMutable() {
+		addition["k"] = request.Item.Key
+		addition["seq"] = request.Item.Seq
+		addition["sig"] = request.Item.Sig
+		if len(request.Item.Salt) != 0 {
+			addition["salt"] = request.Item.Salt
+		}
+		if request.Cas >= 0 {
+			addition["cas"] = request.Cas
+		}
+	}
+	protobuf["a"] = addition
+	if bytes, err = Encode(protobuf); err != nil {
+		return
+	}
+	if ctx, err = krpc.BurstRequest(userCtx, request.TransactionId, request, bytes, address); err != nil {
+		return
+	}
+	if err = ctx.remoteError(); err != nil {
+		return
+	}
+	response, err = UnserializePutResponse(ctx.transactionId, ctx.resDict)
+	return
+}
+
+// BEP 51: 获取对方保存的部分infohash, 应答中的Interval秒内不应再次请求同一节点
+func (krpc *KRPC) SampleInfohashes(userCtx context.Context, request *SampleInfohashesRequest, address string) (response *SampleInfohashesResponse, err error) {
+	var (
+		ctx *KRPCContext
+		bytes []byte
+		addition map[string]interface{}
+	)
+
+	// 序列化
+	protobuf := map[string]interface{}{}
+	protobuf["t"] = request.TransactionId
+	protobuf["y"] = request.Type
+	protobuf["q"] = request.Method
+	if krpc.readOnly {
+		protobuf["ro"] = 1
+	}
+	addition = map[string]interface{}{
+		"id": krpc.requestId(request.Id),
+		"target": request.Target,
+	}
+	if len(request.Want) != 0 {
+		addition["want"] = serializeWant(request.Want)
+	}
+	protobuf["a"] = addition
+	if bytes, err = Encode(protobuf); err != nil {
+		return
+	}
+	if ctx, err = krpc.BurstRequest(userCtx, request.TransactionId, request, bytes, address); err != nil {
+		return
+	}
+	if err = ctx.remoteError(); err != nil {
+		return
+	}
+	response, err = UnserializeSampleInfohashesResponse(ctx.transactionId, ctx.resDict)
+	return
+}
+
+func serializeWant(want []string) []interface{} {
+	list := make([]interface{}, 0, len(want))
+	for _, family := range want {
+		list = append(list, family)
+	}
+	return list
+}
+
+// 双栈时同时请求IPv4与IPv6节点, 否则由对方按来源地址族决定
+func (krpc *KRPC) defaultWant() []string {
+	if krpc.network == "udp" {
+		return []string{WANT_NODES, WANT_NODES6}
+	}
+	return nil
+}
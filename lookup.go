@@ -0,0 +1,233 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+const (
+	LOOKUP_ALPHA = 3 // 迭代查询的并发度
+
+	// 迭代查询发送的请求
+	LOOKUP_FIND_NODE = 0 // find_node
+	LOOKUP_GET_PEERS = 1 // get_peers
+	LOOKUP_SCRAPE = 2 // get_peers, 并要求返回BEP 33布隆过滤器
+)
+
+// 迭代查询的结果
+type LookupResult struct {
+	Nodes []*CompactNode // 距离target最近的KNODES个已应答节点
+	Peers []string // get_peers收集到的peer地址
+	Tokens map[string]string // 节点地址 -> get_peers应答中的token
+	BFsd *BloomFilter // scrape时, Nodes返回的做种者过滤器合并结果
+	BFpe *BloomFilter // scrape时, Nodes返回的下载者过滤器合并结果
+}
+
+// 候选节点
+type lookupEntry struct {
+	node *CompactNode
+	queried bool // 已发出请求
+	responded bool // 已应答
+	failed bool // 请求失败
+	bfsd *BloomFilter // 应答中的BFsd
+	bfpe *BloomFilter // 应答中的BFpe
+}
+
+// 按到target的异或距离排序的候选列表
+type lookupShortlist struct {
+	target string
+	entries []*lookupEntry
+}
+
+func (shortlist *lookupShortlist) Len() int {
+	return len(shortlist.entries)
+}
+
+func (shortlist *lookupShortlist) Swap(i, j int) {
+	shortlist.entries[i], shortlist.entries[j] = shortlist.entries[j], shortlist.entries[i]
+}
+
+func (shortlist *lookupShortlist) Less(i, j int) bool {
+	return compareDistance(shortlist.target, shortlist.entries[i].node.Id, shortlist.entries[j].node.Id) < 0
+}
+
+// 单次查询的应答
+type lookupReply struct {
+	entry *lookupEntry
+	id string // 应答方ID
+	nodes []*CompactNode
+	values []string
+	token string
+	bfsd *BloomFilter
+	bfpe *BloomFilter
+	err error
+}
+
+// 迭代find_node, 返回距离target最近的节点
+func (node *DHTNode) FindNodeLookup(ctx context.Context, target string) (*LookupResult, error) {
+	return node.lookup(ctx, target, LOOKUP_FIND_NODE, node.closestSeeds(target))
+}
+
+// 迭代get_peers, 返回距离infoHash最近的节点, 沿途收集的peer与token
+func (node *DHTNode) GetPeersLookup(ctx context.Context, infoHash string) (*LookupResult, error) {
+	return node.lookup(ctx, infoHash, LOOKUP_GET_PEERS, node.closestSeeds(infoHash))
+}
+
+// 从路由表中取出起始节点
+func (node *DHTNode) closestSeeds(target string) []*CompactNode {
+	return append(node.routingTable.ClosestNodes(target, KNODES), node.routingTable6.ClosestNodes(target, KNODES)...)
+}
+
+// Kademlia迭代查询: 从seeds出发, 始终保持最多LOOKUP_ALPHA个请求在途, 只查询最近的KNODES个候选,
+// 当最近的KNODES个候选都已应答(或失败)时结束, 应答的节点插入路由表. ctx结束时返回已得到的结果与ctx.Err()
+func (node *DHTNode) lookup(ctx context.Context, target string, method int, seeds []*CompactNode) (result *LookupResult, err error) {
+	var (
+		entries = make(map[string]*lookupEntry) // 地址 -> 候选
+		shortlist = &lookupShortlist{target: target}
+		replies = make(chan *lookupReply, LOOKUP_ALPHA)
+		reply *lookupReply
+		inflight int
+		count int
+		peers = make(map[string]bool)
+	)
+
+	addEntry := func(compactNode *CompactNode) {
+		// 不查询自己
+		if len(compactNode.Address) == 0 || compactNode.Id == node.Id() {
+			return
+		}
+		if _, exist := entries[compactNode.Address]; exist {
+			return
+		}
+		entry := &lookupEntry{node: compactNode}
+		entries[compactNode.Address] = entry
+		shortlist.entries = append(shortlist.entries, entry)
+	}
+	for _, seed := range seeds {
+		addEntry(seed)
+	}
+	if len(shortlist.entries) == 0 {
+		return nil, errors.New("no nodes to start lookup")
+	}
+
+	result = &LookupResult{}
+	result.Tokens = make(map[string]string)
+
+	for {
+		// 在最近的KNODES个候选中补足并发请求
+		sort.Sort(shortlist)
+		count = 0
+		for _, entry := range shortlist.entries {
+			if count >= KNODES || inflight >= LOOKUP_ALPHA {
+				break
+			}
+			if entry.failed {
+				continue
+			}
+			count++
+			if !entry.queried {
+				entry.queried = true
+				inflight++
+				go node.lookupQuery(ctx, target, method, entry, replies)
+			}
+		}
+		if inflight == 0 { // 最近的KNODES个都已应答
+			break
+		}
+
+		select {
+		case reply = <- replies:
+		case <- ctx.Done():
+			err = ctx.Err()
+			goto END
+		}
+		inflight--
+
+		if reply.err != nil {
+			reply.entry.failed = true
+			continue
+		}
+		reply.entry.responded = true
+		reply.entry.bfsd, reply.entry.bfpe = reply.bfsd, reply.bfpe
+		if len(reply.entry.node.Id) == 0 { // 例如bootstrap节点, 此前不知道ID
+			reply.entry.node = &CompactNode{Address: reply.entry.node.Address, Id: reply.id}
+		}
+		for _, compactNode := range reply.nodes {
+			addEntry(compactNode)
+		}
+		for _, address := range reply.values {
+			if !peers[address] {
+				peers[address] = true
+				result.Peers = append(result.Peers, address)
+			}
+		}
+		if len(reply.token) != 0 {
+			result.Tokens[reply.entry.node.Address] = reply.token
+		}
+	}
+
+END:
+	sort.Sort(shortlist)
+	result.Nodes = make([]*CompactNode, 0, KNODES)
+	for _, entry := range shortlist.entries {
+		if len(result.Nodes) >= KNODES {
+			break
+		}
+		if entry.responded {
+			result.Nodes = append(result.Nodes, entry.node)
+			result.mergeFilters(entry)
+		}
+	}
+	return result, err
+}
+
+// 合并最近节点返回的布隆过滤器
+func (result *LookupResult) mergeFilters(entry *lookupEntry) {
+	if entry.bfsd != nil {
+		if result.BFsd == nil {
+			result.BFsd = NewBloomFilter()
+		}
+		result.BFsd.Merge(entry.bfsd)
+	}
+	if entry.bfpe != nil {
+		if result.BFpe == nil {
+			result.BFpe = NewBloomFilter()
+		}
+		result.BFpe.Merge(entry.bfpe)
+	}
+}
+
+// 向一个候选发送find_node或get_peers
+func (node *DHTNode) lookupQuery(ctx context.Context, target string, method int, entry *lookupEntry, replies chan *lookupReply) {
+	reply := &lookupReply{entry: entry}
+
+	if method == LOOKUP_GET_PEERS || method == LOOKUP_SCRAPE {
+		request := NewGetPeersRequest()
+		request.InfoHash = target
+		request.Want = node.krpc.defaultWant()
+		if method == LOOKUP_SCRAPE {
+			request.Scrape = 1
+		}
+		if response, err := node.krpc.GetPeers(ctx, request, entry.node.Address); err != nil {
+			reply.err = err
+		} else {
+			reply.id = response.Id
+			reply.nodes = append(response.Nodes, response.Nodes6...)
+			reply.values = response.Values
+			reply.token = response.Token
+			reply.bfsd, reply.bfpe = response.BFsd, response.BFpe
+		}
+	} else {
+		request := NewFindNodeRequest()
+		request.Target = target
+		request.Want = node.krpc.defaultWant()
+		if response, err := node.krpc.FindNode(ctx, request, entry.node.Address); err != nil {
+			reply.err = err
+		} else {
+			reply.id = response.Id
+			reply.nodes = append(response.Nodes, response.Nodes6...)
+		}
+	}
+	replies <- reply
+}
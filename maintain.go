@@ -0,0 +1,69 @@
+package dht
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	MAINTAIN_INTERVAL = time.Duration(1) * time.Minute // 路由表维护间隔
+	MAINTAIN_MAX_PINGS = 16 // 同时ping的节点数上限
+)
+
+// 定期维护路由表(BEP 5): ping questionable节点, 刷新长时间没有变化的桶
+func (node *DHTNode) maintainLoop() {
+	ticker := time.NewTicker(MAINTAIN_INTERVAL)
+	defer ticker.Stop()
+	defer node.maintainWait.Done()
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+	go func() {
+		<- node.closeNotify
+		cancelFunc()
+	}()
+
+	for {
+		select {
+		case <- ticker.C:
+			node.maintain(ctx, node.routingTable)
+			node.maintain(ctx, node.routingTable6)
+		case <- node.closeNotify:
+			return
+		}
+	}
+}
+
+func (node *DHTNode) maintain(ctx context.Context, rt *RoutingTable) {
+	node.pingQuestionable(ctx, rt)
+
+	for _, target := range rt.RefreshTargets() {
+		if ctx.Err() != nil {
+			return
+		}
+		node.FindNodeLookup(ctx, target) // 应答的节点会进入路由表
+	}
+}
+
+// ping应答则恢复good, 超时累计失败次数(均由KRPC完成), 连续失败MAX_FAIL_TIMES次变为bad后可被替换
+func (node *DHTNode) pingQuestionable(ctx context.Context, rt *RoutingTable) {
+	var (
+		wait sync.WaitGroup
+		limit = make(chan byte, MAINTAIN_MAX_PINGS)
+	)
+	for _, compactNode := range rt.QuestionableNodes() {
+		wait.Add(1)
+		limit <- 1
+		go func(compactNode *CompactNode) {
+			defer wait.Done()
+			defer func() { <- limit }()
+
+			// 地址上已经换成了其他节点, 原节点视为失败
+			if response, err := node.krpc.Ping(ctx, NewPingRequest(), compactNode.Address); err == nil && response.Id != compactNode.Id {
+				rt.Fail(compactNode.Id)
+			}
+		}(compactNode)
+	}
+	wait.Wait()
+}
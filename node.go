@@ -0,0 +1,221 @@
+package dht
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+)
+
+// DHT节点, 持有自己的ID, KRPC, 路由表与token, 同一进程内可以运行多个节点
+type DHTNode struct {
+	id string // 节点ID, 由mutex保护
+	krpc *KRPC
+	routingTable *RoutingTable // IPv4路由表
+	routingTable6 *RoutingTable // IPv6路由表 (BEP 32)
+	tokenMgr *TokenManager
+	peerStore *PeerStore // announce_peer宣告的peer
+	itemStore *ItemStore // put保存的item (BEP 44)
+
+	mutex sync.RWMutex
+	peerSink PeerSink // 接收announce事件
+
+	bootstrapNodes []string // 默认引导节点
+	onBootstrapProgress func(progress *BootstrapProgress)
+
+	ownState bool // 路由表与token是否由本节点独占(关闭时一并释放)
+
+	secureId bool // 外部IP变化时按BEP 42重新生成ID
+	voter4 *ipVoter // IPv4外部IP投票
+	voter6 *ipVoter // IPv6外部IP投票
+
+	closeOnce sync.Once
+	closeNotify chan byte // 通知维护协程退出
+	maintainWait sync.WaitGroup
+}
+
+func newNode(options *Options, id string, routingTable *RoutingTable, tokenMgr *TokenManager, restored []*SavedNode) (node *DHTNode, err error) {
+	node = &DHTNode{}
+	node.id = id
+	node.routingTable = routingTable
+	node.routingTable6 = NewRoutingTable(id)
+	node.routingTable.SetIPLimits(options.IPLimits)
+	node.routingTable6.SetIPLimits(options.IPLimits)
+	node.routingTable.SetSecureIdPolicy(options.SecureIdPolicy)
+	node.routingTable6.SetSecureIdPolicy(options.SecureIdPolicy)
+	node.secureId = options.SecureId
+	node.voter4 = newIPVoter()
+	node.voter6 = newIPVoter()
+	node.tokenMgr = tokenMgr
+	node.peerSink = options.PeerSink
+	node.bootstrapNodes = options.BootstrapNodes
+	node.onBootstrapProgress = options.OnBootstrapProgress
+	node.peerStore = NewPeerStore(options.PeerExpire, options.MaxPeersPerInfoHash, options.MaxPeers)
+	node.itemStore = NewItemStore(options.ItemExpire, options.MaxItems)
+	if node.krpc, err = newKRPC(node, options); err != nil {
+		node.peerStore.Close()
+		node.itemStore.Close()
+		return nil, err
+	}
+	node.closeNotify = make(chan byte)
+	node.maintainWait.Add(1)
+	go node.maintainLoop()
+	if len(restored) != 0 {
+		node.maintainWait.Add(1)
+		go node.restoreNodes(restored)
+	}
+	if options.StateFile != "" {
+		node.maintainWait.Add(1)
+		go node.autosaveLoop(options.StateFile, options.AutosaveInterval)
+	}
+	return node, nil
+}
+
+// 读取options.StateFile, 文件不存在视为首次启动
+func loadState(options *Options) (state *RoutingTableState, err error) {
+	if options.StateFile == "" {
+		return nil, nil
+	}
+	if state, err = LoadRoutingTableState(options.StateFile); err != nil && os.IsNotExist(err) {
+		return nil, nil
+	}
+	return
+}
+
+// 创建独立的DHT节点, options.NodeId为空则使用StateFile中保存的ID, 都没有则随机生成
+func NewNode(options *Options) (node *DHTNode, err error) {
+	var (
+		id string
+		tokenMgr *TokenManager
+		state *RoutingTableState
+		restored []*SavedNode
+	)
+	options = options.withDefaults()
+
+	// 二进制ID原样放进报文, 长度不对(例如40字节hex)时远端会拒绝所有消息
+	if len(options.NodeId) != 0 && len(options.NodeId) != len(NodeID{}) {
+		return nil, ErrInvalidNodeId
+	}
+	if state, err = loadState(options); err != nil {
+		return nil, err
+	}
+	if id = options.NodeId; id == "" && state != nil {
+		id = state.Id
+	}
+	if id == "" {
+		id = genNodeId(options)
+	}
+	if state != nil && state.Id == id { // 换了ID则原来的节点位置不再有意义
+		restored = state.Nodes
+	}
+	tokenMgr = CreateTokenManager()
+	if node, err = newNode(options, id, NewRoutingTable(id), tokenMgr, restored); err != nil {
+		tokenMgr.Close()
+		return nil, err
+	}
+	node.ownState = true
+	return node, nil
+}
+
+// 使用进程级单例(MyNodeId, GetRoutingTable, GetTokenManager)的默认节点, 供CreateKPRC使用.
+// 默认节点不持久化路由表, 需要StateFile时使用NewNode或NewKRPC
+func newDefaultNode(options *Options) (node *DHTNode, err error) {
+	options = options.withDefaults()
+	return newNode(options, MyNodeId(), GetRoutingTable(), GetTokenManager(), nil)
+}
+
+func (node *DHTNode) Id() string {
+	node.mutex.RLock()
+	defer node.mutex.RUnlock()
+	return node.id
+}
+
+// 监听公网地址时可以直接生成符合BEP 42的ID, 否则先随机生成, 等外部IP确定后再替换
+func genNodeId(options *Options) string {
+	if ip := net.ParseIP(options.ListenAddr); options.SecureId && ip != nil && !ip.IsUnspecified() && !isLocalIP(ip) {
+		return GenSecureNodeId(ip)
+	}
+	return GenNodeId()
+}
+
+// 更换节点ID, 路由表以新ID为中心重建
+func (node *DHTNode) setId(id string) {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	node.id = id
+	node.routingTable.SetId(id)
+	node.routingTable6.SetId(id)
+}
+
+func (node *DHTNode) KRPC() *KRPC {
+	return node.krpc
+}
+
+func (node *DHTNode) RoutingTable() *RoutingTable {
+	return node.routingTable
+}
+
+func (node *DHTNode) RoutingTable6() *RoutingTable {
+	return node.routingTable6
+}
+
+// 按地址族选择路由表
+func (node *DHTNode) routingTableFor(ip net.IP) *RoutingTable {
+	if isIPv6(ip) {
+		return node.routingTable6
+	}
+	return node.routingTable
+}
+
+func (node *DHTNode) TokenManager() *TokenManager {
+	return node.tokenMgr
+}
+
+func (node *DHTNode) PeerStore() *PeerStore {
+	return node.peerStore
+}
+
+func (node *DHTNode) ItemStore() *ItemStore {
+	return node.itemStore
+}
+
+func (node *DHTNode) PeerSink() PeerSink {
+	node.mutex.RLock()
+	defer node.mutex.RUnlock()
+	return node.peerSink
+}
+
+// 注册接收announce事件的PeerSink, nil表示丢弃
+func (node *DHTNode) SetPeerSink(sink PeerSink) {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	node.peerSink = sink
+}
+
+// 优雅关闭节点, 见KRPC.Shutdown
+func (node *DHTNode) Shutdown(ctx context.Context) error {
+	return node.krpc.Shutdown(ctx)
+}
+
+// 立即关闭节点
+func (node *DHTNode) Close() error {
+	return node.krpc.Close()
+}
+
+// 停止维护协程, 由KRPC关闭时调用
+func (node *DHTNode) stopMaintain() {
+	node.closeOnce.Do(func() {
+		close(node.closeNotify)
+	})
+	node.maintainWait.Wait()
+}
+
+// 释放节点独占的状态, 由KRPC关闭时调用
+func (node *DHTNode) releaseState() {
+	node.peerStore.Close()
+	node.itemStore.Close()
+	if node.ownState {
+		node.tokenMgr.Close()
+	}
+}
@@ -0,0 +1,109 @@
+package dht
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"math/bits"
+)
+
+// 160位节点ID, 用于路由表中的距离计算, 避免big.Int分配
+type NodeID [20]byte
+
+// 20字节二进制ID转换为NodeID, 长度不足的部分补0
+func NewNodeID(id string) (nodeId NodeID) {
+	copy(nodeId[:], id)
+	return
+}
+
+// 二进制形式, 与CompactNode.Id一致
+func (nodeId NodeID) Binary() string {
+	return string(nodeId[:])
+}
+
+func (nodeId NodeID) String() string {
+	return hex.EncodeToString(nodeId[:])
+}
+
+// JSON等文本格式中使用十六进制
+func (nodeId NodeID) MarshalText() ([]byte, error) {
+	return []byte(nodeId.String()), nil
+}
+
+func (nodeId *NodeID) UnmarshalText(text []byte) error {
+	if hex.DecodedLen(len(text)) != len(nodeId) {
+		return hex.ErrLength
+	}
+	_, err := hex.Decode(nodeId[:], text)
+	return err
+}
+
+// 异或距离
+func (nodeId NodeID) Xor(other NodeID) (distance NodeID) {
+	for i := 0; i < len(nodeId); i++ {
+		distance[i] = nodeId[i] ^ other[i]
+	}
+	return
+}
+
+// 按大端比较: -1更小, 0相等, 1更大
+func (nodeId NodeID) Compare(other NodeID) int {
+	return bytes.Compare(nodeId[:], other[:])
+}
+
+// 共同前缀的位数, 0~160
+func (nodeId NodeID) PrefixLen(other NodeID) int {
+	for i := 0; i < len(nodeId); i++ {
+		if diff := nodeId[i] ^ other[i]; diff != 0 {
+			return i * 8 + bits.LeadingZeros8(diff)
+		}
+	}
+	return len(nodeId) * 8
+}
+
+// 第i位(从最高位开始)
+func (nodeId NodeID) Bit(i int) int {
+	return int(nodeId[i / 8] >> uint(7 - i % 8)) & 1
+}
+
+// 翻转第i位
+func (nodeId NodeID) FlipBit(i int) NodeID {
+	nodeId[i / 8] ^= 0x80 >> uint(i % 8)
+	return nodeId
+}
+
+// 比较left, right到target的异或距离: -1更近, 0相等, 1更远
+func CompareDistance(target NodeID, left NodeID, right NodeID) int {
+	for i := 0; i < len(target); i++ {
+		leftByte := left[i] ^ target[i]
+		rightByte := right[i] ^ target[i]
+		if leftByte < rightByte {
+			return -1
+		} else if leftByte > rightByte {
+			return 1
+		}
+	}
+	return 0
+}
+
+// 前prefixLen位替换为prefix的对应位
+func (nodeId NodeID) WithPrefix(prefix NodeID, prefixLen int) NodeID {
+	for i := 0; i < prefixLen / 8; i++ {
+		nodeId[i] = prefix[i]
+	}
+	if remain := prefixLen % 8; remain != 0 {
+		mask := byte(0xff << uint(8 - remain))
+		nodeId[prefixLen / 8] = prefix[prefixLen / 8] & mask | nodeId[prefixLen / 8] &^ mask
+	}
+	return nodeId
+}
+
+// 与prefix共享前prefixLen位的随机ID
+func RandomNodeID(prefix NodeID, prefixLen int) (nodeId NodeID) {
+	for {
+		if _, err := rand.Read(nodeId[:]); err == nil {
+			break
+		}
+	}
+	return nodeId.WithPrefix(prefix, prefixLen)
+}
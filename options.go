@@ -0,0 +1,139 @@
+package dht
+
+import (
+	"time"
+	"runtime"
+)
+
+// 节点与KRPC配置项
+type Options struct {
+	Network string // udp4, udp6, udp(双栈)
+	ListenAddr string // 监听IP, 为空则监听全部地址
+	ListenPort int // 监听端口, 为0则随机端口
+
+	ReqQueueSize int // 发送请求队列长度
+	ResQueueSize int // 发送应答队列长度
+	ProcQueueSize int // 处理外来包队列长度
+	MaxPending int // 同时处理中的请求上限
+	ProcWorkers int // 处理外来包的协程数
+
+	Timeout time.Duration // 默认RPC超时
+	NodeId string // 20字节二进制节点ID, 为空则随机生成
+
+	PeerExpire time.Duration // announce的peer有效期
+	MaxPeersPerInfoHash int // 单个infohash保存的peer上限
+	MaxPeers int // 保存的peer总数上限
+
+	ItemExpire time.Duration // put的item有效期 (BEP 44)
+	MaxItems int // 保存的item总数上限
+
+	PeerSink PeerSink // 接收announce事件, 为nil则丢弃
+
+	BootstrapNodes []string // 加入网络时使用的引导节点(host:port)
+	OnBootstrapProgress func(progress *BootstrapProgress) // 引导进度回调, 可以为nil
+
+	ReadOnly bool // BEP 43: 只发请求, 不应答请求, 其他节点不会把我们加入路由表(例如NAT后的爬虫)
+
+	SecureId bool // BEP 42: 按外部IP生成节点ID, 外部IP变化时重新生成; 默认关闭
+	SecureIdPolicy int // 路由表对不符合BEP 42的节点的处理, SECURE_ID_IGNORE(默认), SECURE_ID_PREFER, SECURE_ID_ENFORCE
+
+	IPLimits IPLimits // 路由表中同一IP, 子网的节点数上限, 各项为0使用默认值, 负数不限制
+
+	StateFile string // 路由表保存路径, 为空则不持久化; 启动时从中恢复节点ID与节点. 只对NewNode, NewKRPC有效
+	AutosaveInterval time.Duration // 路由表自动保存间隔
+}
+
+// 常用的公共引导节点
+var DefaultBootstrapNodes = []string{
+	"router.bittorrent.com:6881",
+	"dht.transmissionbt.com:6881",
+	"router.utorrent.com:6881",
+}
+
+// 默认配置, 与CreateKPRC()的行为一致
+func DefaultOptions() *Options {
+	return &Options{
+		Network: "udp4",
+		ListenAddr: "0.0.0.0",
+		ListenPort: 6881,
+		ReqQueueSize: 100000,
+		ResQueueSize: 100000,
+		ProcQueueSize: 100000,
+		MaxPending: 100000,
+		ProcWorkers: runtime.NumCPU(),
+		Timeout: time.Duration(1) * time.Second,
+		PeerExpire: time.Duration(30) * time.Minute,
+		MaxPeersPerInfoHash: 1000,
+		MaxPeers: 1000000,
+		ItemExpire: time.Duration(2) * time.Hour,
+		MaxItems: 100000,
+		PeerSink: &StdoutSink{},
+		BootstrapNodes: DefaultBootstrapNodes,
+		SecureId: false,
+		SecureIdPolicy: SECURE_ID_IGNORE,
+		IPLimits: DefaultIPLimits,
+		AutosaveInterval: AUTOSAVE_INTERVAL,
+	}
+}
+
+// 未填写的配置项使用默认值, options为nil则全部使用默认值
+func (options *Options) withDefaults() *Options {
+	defaults := DefaultOptions()
+	if options == nil {
+		return defaults
+	}
+	merged := *options
+
+	if merged.Network == "" {
+		merged.Network = defaults.Network
+	}
+	if merged.ReqQueueSize <= 0 {
+		merged.ReqQueueSize = defaults.ReqQueueSize
+	}
+	if merged.ResQueueSize <= 0 {
+		merged.ResQueueSize = defaults.ResQueueSize
+	}
+	if merged.ProcQueueSize <= 0 {
+		merged.ProcQueueSize = defaults.ProcQueueSize
+	}
+	if merged.MaxPending <= 0 {
+		merged.MaxPending = defaults.MaxPending
+	}
+	if merged.ProcWorkers <= 0 {
+		merged.ProcWorkers = defaults.ProcWorkers
+	}
+	if merged.Timeout <= 0 {
+		merged.Timeout = defaults.Timeout
+	}
+	if merged.PeerExpire <= 0 {
+		merged.PeerExpire = defaults.PeerExpire
+	}
+	if merged.MaxPeersPerInfoHash <= 0 {
+		merged.MaxPeersPerInfoHash = defaults.MaxPeersPerInfoHash
+	}
+	if merged.MaxPeers <= 0 {
+		merged.MaxPeers = defaults.MaxPeers
+	}
+	if merged.ItemExpire <= 0 {
+		merged.ItemExpire = defaults.ItemExpire
+	}
+	if merged.MaxItems <= 0 {
+		merged.MaxItems = defaults.MaxItems
+	}
+	if merged.IPLimits.BucketPerIP == 0 {
+		merged.IPLimits.BucketPerIP = defaults.IPLimits.BucketPerIP
+	}
+	if merged.IPLimits.BucketPerSubnet == 0 {
+		merged.IPLimits.BucketPerSubnet = defaults.IPLimits.BucketPerSubnet
+	}
+	if merged.IPLimits.TablePerIP == 0 {
+		merged.IPLimits.TablePerIP = defaults.IPLimits.TablePerIP
+	}
+	if merged.IPLimits.TablePerSubnet == 0 {
+		merged.IPLimits.TablePerSubnet = defaults.IPLimits.TablePerSubnet
+	}
+	if merged.AutosaveInterval <= 0 {
+		merged.AutosaveInterval = defaults.AutosaveInterval
+	}
+	return &merged
+}
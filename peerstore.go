@@ -0,0 +1,198 @@
+package dht
+
+import (
+	"sync"
+	"time"
+	"net"
+)
+
+const (
+	MAX_PEER_VALUES = 50 // 一次get_peers最多返回的peer数量, 避免UDP包过大
+)
+
+// 保存的peer
+type storedPeer struct {
+	expireTime int64 // 过期时间
+	seed bool // 宣告时带有seed=1 (BEP 33)
+}
+
+// 内存中保存announce_peer宣告的peer, 按infohash索引, 每个peer独立过期
+type PeerStore struct {
+	mutex sync.Mutex
+	peers map[string]map[string]storedPeer // infohash -> peer地址 -> peer
+	count int // peer总数
+
+	expire time.Duration // peer有效期
+	maxPerInfoHash int // 单个infohash的peer上限
+	maxPeers int // peer总数上限
+
+	closeOnce sync.Once
+	closeNotify chan byte // 通知停止清理
+}
+
+func NewPeerStore(expire time.Duration, maxPerInfoHash int, maxPeers int) (store *PeerStore) {
+	store = &PeerStore{}
+	store.peers = make(map[string]map[string]storedPeer)
+	store.expire = expire
+	store.maxPerInfoHash = maxPerInfoHash
+	store.maxPeers = maxPeers
+	store.closeNotify = make(chan byte)
+	go store.cleanLoop()
+	return store
+}
+
+// 定期清理过期的peer
+func (store *PeerStore) cleanLoop() {
+	ticker := time.NewTicker(time.Duration(1) * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <- ticker.C:
+			store.clean(time.Now().Unix())
+		case <- store.closeNotify:
+			return
+		}
+	}
+}
+
+func (store *PeerStore) clean(now int64) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for infoHash, peers := range store.peers {
+		for address, peer := range peers {
+			if peer.expireTime <= now {
+				delete(peers, address)
+				store.count--
+			}
+		}
+		if len(peers) == 0 {
+			delete(store.peers, infoHash)
+		}
+	}
+}
+
+// 停止清理
+func (store *PeerStore) Close() {
+	store.closeOnce.Do(func() {
+		close(store.closeNotify)
+	})
+}
+
+// 保存peer, 已存在则刷新过期时间与是否做种, 超过上限返回false
+func (store *PeerStore) AddPeer(infoHash string, address string, seed bool) bool {
+	var (
+		peers map[string]storedPeer
+		exist bool
+	)
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	peer := storedPeer{expireTime: time.Now().Add(store.expire).Unix(), seed: seed}
+
+	if peers, exist = store.peers[infoHash]; exist {
+		if _, exist = peers[address]; exist {
+			peers[address] = peer
+			return true
+		}
+	}
+	if store.count >= store.maxPeers {
+		return false
+	}
+	if peers == nil {
+		peers = make(map[string]storedPeer)
+		store.peers[infoHash] = peers
+	}
+	if len(peers) >= store.maxPerInfoHash {
+		return false
+	}
+	peers[address] = peer
+	store.count++
+	return true
+}
+
+// 返回infohash下未过期的peer(最多max个), 按地址族过滤, noSeed时跳过做种者
+func (store *PeerStore) GetPeers(infoHash string, max int, wantIPv4 bool, wantIPv6 bool, noSeed bool) (values []string) {
+	var ip net.IP
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := time.Now().Unix()
+	values = make([]string, 0)
+
+	// map遍历顺序随机, 相当于随机抽样
+	for address, peer := range store.peers[infoHash] {
+		if len(values) >= max {
+			break
+		}
+		if peer.expireTime <= now || noSeed && peer.seed {
+			continue
+		}
+		if ip = addressIP(address); ip == nil {
+			continue
+		}
+		if isIPv6(ip) && wantIPv6 || !isIPv6(ip) && wantIPv4 {
+			values = append(values, address)
+		}
+	}
+	return
+}
+
+// 以infohash下未过期peer的IP生成做种者与下载者的布隆过滤器(BEP 33), 没有peer时返回nil
+func (store *PeerStore) ScrapeFilters(infoHash string) (seeds *BloomFilter, peers *BloomFilter) {
+	var ip net.IP
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if len(store.peers[infoHash]) == 0 {
+		return nil, nil
+	}
+
+	now := time.Now().Unix()
+	seeds = NewBloomFilter()
+	peers = NewBloomFilter()
+	for address, peer := range store.peers[infoHash] {
+		if peer.expireTime <= now {
+			continue
+		}
+		if ip = addressIP(address); ip == nil {
+			continue
+		}
+		if peer.seed {
+			seeds.Insert(ip)
+		} else {
+			peers.Insert(ip)
+		}
+	}
+	return
+}
+
+// 随机抽取最多max个有peer的infohash (BEP 51)
+func (store *PeerStore) SampleInfoHashes(max int) (samples []string) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	samples = make([]string, 0, max)
+	for infoHash := range store.peers { // map遍历顺序本身是随机的
+		if len(samples) >= max {
+			break
+		}
+		samples = append(samples, infoHash)
+	}
+	return
+}
+
+// 保存的infohash数量
+func (store *PeerStore) InfoHashCount() int {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return len(store.peers)
+}
+
+// 保存的peer总数
+func (store *PeerStore) Size() int {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return store.count
+}
@@ -0,0 +1,243 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	ROUTING_TABLE_VERSION = 1 // 路由表文件格式版本
+	AUTOSAVE_INTERVAL = time.Duration(5) * time.Minute // 默认自动保存间隔
+)
+
+// 保存的节点及其上次活跃时间
+type SavedNode struct {
+	Info *CompactNode
+	LastSeen time.Time
+}
+
+// 路由表的持久化状态: 本节点ID与good/questionable节点
+type RoutingTableState struct {
+	Version int
+	Id string
+	Nodes []*SavedNode
+}
+
+// 当前路由表的状态, 不包含bad节点
+func (rt *RoutingTable) State() (state *RoutingTableState) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+
+	state = &RoutingTableState{Version: ROUTING_TABLE_VERSION, Id: rt.myId}
+	for _, bucket := range rt.buckets {
+		for _, node := range bucket.nodes {
+			if node.status == NODE_STATUS_BAD {
+				continue
+			}
+			state.Nodes = append(state.Nodes, &SavedNode{Info: node.info, LastSeen: time.Unix(node.lastActive, 0)})
+		}
+	}
+	return
+}
+
+// 保存路由表到文件
+func (rt *RoutingTable) Save(path string) error {
+	return rt.State().Save(path)
+}
+
+// 编码为bencode: {"v": 版本, "id": 节点ID, "nodes": [{"id", "addr"(紧凑格式), "seen"}]}
+func (state *RoutingTableState) Serialize() ([]byte, error) {
+	var (
+		nodes = make([]interface{}, 0, len(state.Nodes))
+		addr string
+		err error
+	)
+	for _, node := range state.Nodes {
+		if addr, err = SerializePeerInfo(node.Info.Address); err != nil {
+			continue // 没有地址的节点无法恢复
+		}
+		nodes = append(nodes, map[string]interface{}{
+			"id": node.Info.Id,
+			"addr": addr,
+			"seen": int(node.LastSeen.Unix()),
+		})
+	}
+	return Encode(map[string]interface{}{
+		"v": state.Version,
+		"id": state.Id,
+		"nodes": nodes,
+	})
+}
+
+// 保存到文件, 中途退出不会破坏已有的文件
+func (state *RoutingTableState) Save(path string) error {
+	data, err := state.Serialize()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// 先写临时文件再重命名, 避免中途退出留下不完整的文件
+func writeFileAtomic(path string, data []byte) (err error) {
+	var tmpFile *os.File
+
+	if tmpFile, err = ioutil.TempFile(filepath.Dir(path), filepath.Base(path) + ".tmp"); err != nil {
+		return
+	}
+	if _, err = tmpFile.Write(data); err != nil {
+		goto ERROR
+	}
+	if err = tmpFile.Sync(); err != nil {
+		goto ERROR
+	}
+	if err = tmpFile.Close(); err != nil {
+		os.Remove(tmpFile.Name())
+		return
+	}
+	if err = os.Rename(tmpFile.Name(), path); err != nil {
+		os.Remove(tmpFile.Name())
+	}
+	return
+ERROR:
+	tmpFile.Close()
+	os.Remove(tmpFile.Name())
+	return
+}
+
+var ErrInvalidState = errors.New("invalid routing table state")
+
+func UnserializeRoutingTableState(data []byte) (state *RoutingTableState, err error) {
+	var (
+		iState interface{}
+		stateDict map[string]interface{}
+		nodeList []interface{}
+		typeOk bool
+	)
+	if iState, err = Decode(data); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidState, err)
+	}
+	if stateDict, typeOk = iState.(map[string]interface{}); !typeOk {
+		goto ERROR
+	}
+
+	state = &RoutingTableState{}
+	if state.Version, typeOk = stateDict["v"].(int); !typeOk {
+		goto ERROR
+	}
+	if state.Version != ROUTING_TABLE_VERSION {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidState, state.Version)
+	}
+	if state.Id, typeOk = stateDict["id"].(string); !typeOk || len(state.Id) != len(NodeID{}) {
+		goto ERROR
+	}
+	if nodeList, typeOk = stateDict["nodes"].([]interface{}); !typeOk {
+		goto ERROR
+	}
+	for _, iNode := range nodeList {
+		var (
+			nodeDict map[string]interface{}
+			id, addr, address string
+			seen int
+		)
+		// 单个节点损坏不影响其他节点
+		if nodeDict, typeOk = iNode.(map[string]interface{}); !typeOk {
+			continue
+		}
+		if id, typeOk = nodeDict["id"].(string); !typeOk || len(id) != len(NodeID{}) {
+			continue
+		}
+		if addr, typeOk = nodeDict["addr"].(string); !typeOk {
+			continue
+		}
+		if address, err = UnserializePeerInfo(addr); err != nil {
+			continue
+		}
+		seen, _ = nodeDict["seen"].(int)
+		state.Nodes = append(state.Nodes, &SavedNode{
+			Info: &CompactNode{Address: address, Id: id},
+			LastSeen: time.Unix(int64(seen), 0),
+		})
+	}
+	return state, nil
+ERROR:
+	return nil, ErrInvalidState
+}
+
+// 从文件加载路由表状态, 文件不存在时返回的err满足os.IsNotExist
+func LoadRoutingTableState(path string) (state *RoutingTableState, err error) {
+	var data []byte
+	if data, err = ioutil.ReadFile(path); err != nil {
+		return
+	}
+	return UnserializeRoutingTableState(data)
+}
+
+// 保存节点ID与IPv4, IPv6路由表到同一个文件
+func (node *DHTNode) SaveState(path string) error {
+	state := node.routingTable.State()
+	state.Id = node.Id()
+	state.Nodes = append(state.Nodes, node.routingTable6.State().Nodes...)
+	return state.Save(path)
+}
+
+// 按间隔自动保存, 退出时再保存一次
+func (node *DHTNode) autosaveLoop(path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	defer node.maintainWait.Done()
+
+	for {
+		select {
+		case <- ticker.C:
+			node.SaveState(path)
+		case <- node.closeNotify:
+			node.SaveState(path)
+			return
+		}
+	}
+}
+
+// 恢复的节点可能已经下线或换了主人, ping应答后才进入路由表, 最近活跃的优先
+func (node *DHTNode) restoreNodes(nodes []*SavedNode) {
+	defer node.maintainWait.Done()
+
+	var (
+		wait sync.WaitGroup
+		limit = make(chan byte, MAINTAIN_MAX_PINGS)
+	)
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+	go func() {
+		select {
+		case <- node.closeNotify:
+			cancelFunc()
+		case <- ctx.Done():
+		}
+	}()
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].LastSeen.After(nodes[j].LastSeen)
+	})
+	for _, savedNode := range nodes {
+		if ctx.Err() != nil {
+			break
+		}
+		wait.Add(1)
+		limit <- 1
+		go func(compactNode *CompactNode) {
+			defer wait.Done()
+			defer func() { <- limit }()
+
+			node.krpc.Ping(ctx, NewPingRequest(), compactNode.Address) // 应答的节点由KRPC加入路由表
+		}(savedNode.Info)
+	}
+	wait.Wait()
+}
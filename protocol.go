@@ -3,17 +3,32 @@ package dht
 import (
 	"crypto/rand"
 	"crypto/sha1"
-	"sync/atomic"
 	"strconv"
 	"sync"
-	"time"
 	"errors"
 	"encoding/binary"
-	"fmt"
 	"encoding/hex"
+	"fmt"
 	"net"
 )
 
+const (
+	COMPACT_NODE_SIZE = 26 // 20字节ID + 4字节IPv4 + 2字节端口
+	COMPACT_NODE6_SIZE = 38 // 20字节ID + 16字节IPv6 + 2字节端口
+	COMPACT_PEER_SIZE = 6 // 4字节IPv4 + 2字节端口
+	COMPACT_PEER6_SIZE = 18 // 16字节IPv6 + 2字节端口
+	TRANSACTION_ID_SIZE = 4 // 请求ID的字节数
+
+	// BEP 32: want参数
+	WANT_NODES = "n4"
+	WANT_NODES6 = "n6"
+
+	// BEP 51: sample_infohashes
+	MAX_SAMPLES = 20 // 一次应答最多返回的infohash数量, 避免UDP包过大
+	SAMPLE_INTERVAL = 300 // 建议对方再次请求的间隔(秒)
+	MAX_SAMPLE_INTERVAL = 21600 // interval上限(秒)
+)
+
 type CompactNode struct {
 	Address string
 	Id string
@@ -22,7 +37,7 @@ type CompactNode struct {
 type ProtocolBase struct {
 	TransactionId string // t: 请求唯一ID标识
 	Type string // y: 消息类型(q,r,e)
-	Id string	// id（request是请求方id, response是应答方id)
+	Id string	// id（request是请求方id, 为空则发送时使用本节点ID; response是应答方id)
 }
 
 type BaseRequest struct {
@@ -32,6 +47,7 @@ type BaseRequest struct {
 
 type BaseResponse struct {
 	ProtocolBase
+	IP string // ip: 请求方的外部地址, 紧凑格式 (BEP 42)
 }
 
 // PING
@@ -47,52 +63,126 @@ type PingResponse struct {
 type FindNodeRequest struct {
 	BaseRequest
 	Target string
+	Want []string // n4, n6 (BEP 32)
 }
 
 type FindNodeResponse struct {
 	BaseResponse
 	Nodes []*CompactNode
+	Nodes6 []*CompactNode // IPv6节点 (BEP 32)
 }
 
 // GET PEERS
 type GetPeersRequest struct {
 	BaseRequest
 	InfoHash string
+	Want []string // n4, n6 (BEP 32)
+	Scrape int // 1: 要求返回BFsd, BFpe (BEP 33)
+	NoSeed int // 1: values中不要返回做种者 (BEP 33)
 }
 
 type GetPeersResponse struct {
 	BaseResponse
 	Token string
 	Nodes []*CompactNode
+	Nodes6 []*CompactNode // IPv6节点 (BEP 32)
 	Values []string // ip:port address..
+	BFsd *BloomFilter // 做种者的布隆过滤器 (BEP 33)
+	BFpe *BloomFilter // 下载者的布隆过滤器 (BEP 33)
 }
 
 // ANNOUNCE PEER
 type AnnouncePeerRequest struct {
 	BaseRequest
-	ImpliedPort int
+	ImpliedPort int // 1: 对方使用UDP来源端口作为peer端口, 忽略Port
 	InfoHash string
 	Port int
-	Token string
+	Token string // get_peers应答中获得的token
+	Seed int // 1: 宣告自己是做种者 (BEP 33)
 }
 
 type AnnouncePeerResponse struct {
 	BaseResponse
 }
 
+// GET (BEP 44)
+type GetRequest struct {
+	BaseRequest
+	Target string
+	Seq int // 只获取seq更大的可变item, 小于0表示不限制
+	Want []string // n4, n6 (BEP 32)
+}
+
+type GetResponse struct {
+	BaseResponse
+	Token string
+	Nodes []*CompactNode
+	Nodes6 []*CompactNode // IPv6节点 (BEP 32)
+	Item *Item // 对方保存的item, 没有则为nil; 应答不含salt, 由请求方自行填写后校验
+}
+
+// PUT (BEP 44)
+type PutRequest struct {
+	BaseRequest
+	Token string // get应答中获得的token
+	Item *Item
+	Cas int // 可变item的期望当前seq, 小于0表示不使用
+}
+
+type PutResponse struct {
+	BaseResponse
+}
+
+// SAMPLE INFOHASHES (BEP 51)
+type SampleInfohashesRequest struct {
+	BaseRequest
+	Target string
+	Want []string // n4, n6 (BEP 32)
+}
+
+type SampleInfohashesResponse struct {
+	BaseResponse
+	Interval int // 再次请求前应等待的秒数
+	Num int // 对方保存的infohash总数
+	Samples []string // 20字节infohash
+	Nodes []*CompactNode
+	Nodes6 []*CompactNode // IPv6节点 (BEP 32)
+}
+
 func NewCompactNode(id string, addr *net.UDPAddr) (compactNode *CompactNode) {
 	compactNode = &CompactNode{}
 	compactNode.Id = id
-	compactNode.Address = fmt.Sprintf("%d.%d.%d.%d:%d", addr.IP[0], addr.IP[1], addr.IP[2], addr.IP[3], addr.Port)
+	compactNode.Address = formatAddress(addr.IP, addr.Port)
 	return compactNode
 }
 
+// ip:port, IPv6为[ip]:port
+func formatAddress(ip net.IP, port int) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return net.JoinHostPort(ip.String(), strconv.Itoa(port))
+}
+
+// 是否IPv6地址(IPv4-mapped地址视为IPv4)
+func isIPv6(ip net.IP) bool {
+	return ip.To4() == nil && ip.To16() != nil
+}
+
+func (compactNode *CompactNode) IsIPv6() bool {
+	host, _, err := net.SplitHostPort(compactNode.Address)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && isIPv6(ip)
+}
+
 func NewPingRequest() (request *PingRequest) {
 	request = &PingRequest{}
 	request.TransactionId = GenTransactionId()
 	request.Type = "q"
 	request.Method = "ping"
-	request.Id = MyNodeId()
 	return request
 }
 
@@ -101,7 +191,6 @@ func NewFindNodeRequest() (request *FindNodeRequest) {
 	request.TransactionId = GenTransactionId()
 	request.Type = "q"
 	request.Method = "find_node"
-	request.Id = MyNodeId()
 	return request
 }
 
@@ -110,7 +199,32 @@ func NewGetPeersRequest() (request *GetPeersRequest) {
 	request.TransactionId = GenTransactionId()
 	request.Type = "q"
 	request.Method = "get_peers"
-	request.Id = MyNodeId()
+	return request
+}
+
+func NewGetRequest() (request *GetRequest) {
+	request = &GetRequest{}
+	request.TransactionId = GenTransactionId()
+	request.Type = "q"
+	request.Method = "get"
+	request.Seq = -1
+	return request
+}
+
+func NewPutRequest() (request *PutRequest) {
+	request = &PutRequest{}
+	request.TransactionId = GenTransactionId()
+	request.Type = "q"
+	request.Method = "put"
+	request.Cas = -1
+	return request
+}
+
+func NewSampleInfohashesRequest() (request *SampleInfohashesRequest) {
+	request = &SampleInfohashesRequest{}
+	request.TransactionId = GenTransactionId()
+	request.Type = "q"
+	request.Method = "sample_infohashes"
 	return request
 }
 
@@ -120,27 +234,71 @@ func NewAnnouncePeerRequest() (request *AnnouncePeerRequest) {
 	request.Type = "q"
 	request.Method = "announce_peer"
 	request.ImpliedPort = 0
-	request.Id = MyNodeId()
 	return request
 }
 
+// 解析26字节(IPv4)或38字节(IPv6)的compact node info
 func UnserializeCompactNode(nodeInfo string) (*CompactNode, error) {
-	if len(nodeInfo) != 26 {
+	if len(nodeInfo) != COMPACT_NODE_SIZE && len(nodeInfo) != COMPACT_NODE6_SIZE {
 		return nil, errors.New("compact node invalid")
 	}
 	compactNode := &CompactNode{}
 	compactNode.Id = nodeInfo[0:20]
-	port := binary.BigEndian.Uint16([]byte(nodeInfo[24:26]))
-	compactNode.Address = fmt.Sprintf("%d.%d.%d.%d:%d", nodeInfo[20], nodeInfo[21], nodeInfo[22], nodeInfo[23], port)
+	compactNode.Address, _ = UnserializePeerInfo(nodeInfo[20:])
 	return compactNode, nil
 }
 
+// 解析连续排列的compact node info
+func UnserializeCompactNodes(nodes string, size int) (compactNodes []*CompactNode, err error) {
+	var (
+		compactNode *CompactNode
+	)
+	compactNodes = make([]*CompactNode, 0)
+	if len(nodes) % size != 0 {
+		return nil, errors.New("compact nodes invalid")
+	}
+	for i := 0; i < len(nodes); i += size {
+		if compactNode, err = UnserializeCompactNode(nodes[i:i + size]); err != nil {
+			return nil, err
+		}
+		compactNodes = append(compactNodes, compactNode)
+	}
+	return compactNodes, nil
+}
+
+// 解析6字节(IPv4)或18字节(IPv6)的compact peer info
 func UnserializePeerInfo(peerInfo string) (string, error) {
-	if len(peerInfo) != 6 {
+	if len(peerInfo) != COMPACT_PEER_SIZE && len(peerInfo) != COMPACT_PEER6_SIZE {
 		return "", errors.New("compact peer invalid")
 	}
-	port := binary.BigEndian.Uint16([]byte(peerInfo[4:6]))
-	return fmt.Sprintf("%d.%d.%d.%d:%d", peerInfo[0], peerInfo[1], peerInfo[2], peerInfo[3], port), nil
+	ipLen := len(peerInfo) - 2
+	ip := net.IP([]byte(peerInfo[:ipLen]))
+	port := binary.BigEndian.Uint16([]byte(peerInfo[ipLen:]))
+	return formatAddress(ip, int(port)), nil
+}
+
+// ip:port序列化为6字节(IPv4)或18字节(IPv6)的compact peer info
+func SerializePeerInfo(address string) (string, error) {
+	var (
+		addr *net.UDPAddr
+		err error
+		peerInfo []byte
+	)
+	if addr, err = net.ResolveUDPAddr("udp", address); err != nil {
+		return "", err
+	}
+	if addr.IP == nil {
+		return "", errors.New("peer address invalid")
+	}
+	if ip4 := addr.IP.To4(); ip4 != nil {
+		peerInfo = make([]byte, COMPACT_PEER_SIZE)
+		copy(peerInfo, ip4)
+	} else {
+		peerInfo = make([]byte, COMPACT_PEER6_SIZE)
+		copy(peerInfo, addr.IP.To16())
+	}
+	binary.BigEndian.PutUint16(peerInfo[len(peerInfo) - 2:], uint16(addr.Port))
+	return string(peerInfo), nil
 }
 
 func UnserializePingResponse(transactionId string, resDict map[string]interface{}) (response *PingResponse, err error) {
@@ -162,7 +320,7 @@ func UnserializePingResponse(transactionId string, resDict map[string]interface{
 	}
 	return response, nil
 ERROR:
-	return nil, errors.New("invalid ping response")
+	return nil, fmt.Errorf("%w: ping", ErrInvalidResponse)
 }
 
 func UnserializeFindNodeResponse(transactionId string, resDict map[string]interface{}) (response *FindNodeResponse, err error) {
@@ -171,14 +329,13 @@ func UnserializeFindNodeResponse(transactionId string, resDict map[string]interf
 		exist bool
 		typeOk bool
 		nodes string
-		compactNode *CompactNode
-		nodesSplit string
 	)
 
 	response = &FindNodeResponse{}
 	response.TransactionId = transactionId
 	response.Type = "r"
 	response.Nodes = make([]*CompactNode, 0)
+	response.Nodes6 = make([]*CompactNode, 0)
 
 	if iField, exist = resDict["id"]; !exist {
 		goto ERROR
@@ -187,27 +344,26 @@ func UnserializeFindNodeResponse(transactionId string, resDict map[string]interf
 		goto ERROR
 	}
 
+	// closest nodes解析compactNode
 	if iField, exist = resDict["nodes"]; exist {
 		if nodes, typeOk = iField.(string); !typeOk {
 			goto ERROR
 		}
-		if len(nodes) % 26 != 0 {
+		if response.Nodes, err = UnserializeCompactNodes(nodes, COMPACT_NODE_SIZE); err != nil {
 			goto ERROR
 		}
-		for i := 0; i <= len(nodes); i++  {
-			if i % 26 == 0 && i != 0 {
-				nodesSplit = nodes[i - 26:i]
-				// closest nodes解析compactNode
-				if compactNode, err = UnserializeCompactNode(nodesSplit); err != nil {
-					goto ERROR
-				}
-				response.Nodes = append(response.Nodes, compactNode)
-			}
+	}
+	if iField, exist = resDict["nodes6"]; exist {
+		if nodes, typeOk = iField.(string); !typeOk {
+			goto ERROR
+		}
+		if response.Nodes6, err = UnserializeCompactNodes(nodes, COMPACT_NODE6_SIZE); err != nil {
+			goto ERROR
 		}
 	}
 	return response, nil
 ERROR:
-	return nil, errors.New("invalid find_node response")
+	return nil, fmt.Errorf("%w: find_node", ErrInvalidResponse)
 }
 
 func UnserializeGetPeersResponse(transactionId string, resDict map[string]interface{}) (response *GetPeersResponse, err error) {
@@ -216,17 +372,17 @@ func UnserializeGetPeersResponse(transactionId string, resDict map[string]interf
 		exist bool
 		typeOk bool
 		nodes string
-		nodesSplit string
-		compactNode *CompactNode
 		peers []interface{}
 		peerInfo string
 		address string
+		bits string
 	)
 
 	response = &GetPeersResponse{}
 	response.TransactionId = transactionId
 	response.Type = "r"
 	response.Nodes = make([]*CompactNode, 0)
+	response.Nodes6 = make([]*CompactNode, 0)
 	response.Values = make([]string, 0)
 
 	if iField, exist = resDict["id"]; !exist {
@@ -236,6 +392,12 @@ func UnserializeGetPeersResponse(transactionId string, resDict map[string]interf
 		goto ERROR
 	}
 
+	if iField, exist = resDict["token"]; exist {
+		if response.Token, typeOk = iField.(string); !typeOk {
+			goto ERROR
+		}
+	}
+
 	if iField, exist = resDict["values"]; exist {
 		if peers, typeOk = iField.([]interface{}); !typeOk {
 			goto ERROR
@@ -252,48 +414,254 @@ func UnserializeGetPeersResponse(transactionId string, resDict map[string]interf
 		}
 	}
 
+	// BEP 33布隆过滤器
+	if iField, exist = resDict["BFsd"]; exist {
+		if bits, typeOk = iField.(string); !typeOk {
+			goto ERROR
+		}
+		if response.BFsd, err = UnserializeBloomFilter(bits); err != nil {
+			goto ERROR
+		}
+	}
+	if iField, exist = resDict["BFpe"]; exist {
+		if bits, typeOk = iField.(string); !typeOk {
+			goto ERROR
+		}
+		if response.BFpe, err = UnserializeBloomFilter(bits); err != nil {
+			goto ERROR
+		}
+	}
+
+	// target解析compactNode
+	if iField, exist = resDict["nodes"]; exist {
+		if nodes, typeOk = iField.(string); !typeOk {
+			goto ERROR
+		}
+		if response.Nodes, err = UnserializeCompactNodes(nodes, COMPACT_NODE_SIZE); err != nil {
+			goto ERROR
+		}
+	}
+	if iField, exist = resDict["nodes6"]; exist {
+		if nodes, typeOk = iField.(string); !typeOk {
+			goto ERROR
+		}
+		if response.Nodes6, err = UnserializeCompactNodes(nodes, COMPACT_NODE6_SIZE); err != nil {
+			goto ERROR
+		}
+	}
+	return response, nil
+ERROR:
+	return nil, fmt.Errorf("%w: get_peers", ErrInvalidResponse)
+}
+
+func UnserializeGetResponse(transactionId string, resDict map[string]interface{}) (response *GetResponse, err error) {
+	var (
+		iField interface{}
+		exist bool
+		typeOk bool
+		nodes string
+		item *Item
+	)
+
+	response = &GetResponse{}
+	response.TransactionId = transactionId
+	response.Type = "r"
+	response.Nodes = make([]*CompactNode, 0)
+	response.Nodes6 = make([]*CompactNode, 0)
+
+	if iField, exist = resDict["id"]; !exist {
+		goto ERROR
+	}
+	if response.Id, typeOk = iField.(string); !typeOk {
+		goto ERROR
+	}
+
+	if iField, exist = resDict["token"]; exist {
+		if response.Token, typeOk = iField.(string); !typeOk {
+			goto ERROR
+		}
+	}
+
 	if iField, exist = resDict["nodes"]; exist {
 		if nodes, typeOk = iField.(string); !typeOk {
 			goto ERROR
 		}
-		if len(nodes) % 26 != 0 {
+		if response.Nodes, err = UnserializeCompactNodes(nodes, COMPACT_NODE_SIZE); err != nil {
 			goto ERROR
 		}
-		for i := 0; i <= len(nodes); i++  {
-			if i % 26 == 0 && i != 0 {
-				nodesSplit = nodes[i - 26:i]
-				// target解析compactNode
-				if compactNode, err = UnserializeCompactNode(nodesSplit); err != nil {
-					goto ERROR
-				}
-				response.Nodes = append(response.Nodes, compactNode)
+	}
+	if iField, exist = resDict["nodes6"]; exist {
+		if nodes, typeOk = iField.(string); !typeOk {
+			goto ERROR
+		}
+		if response.Nodes6, err = UnserializeCompactNodes(nodes, COMPACT_NODE6_SIZE); err != nil {
+			goto ERROR
+		}
+	}
+
+	// item, 可变item还有k, seq, sig
+	if iField, exist = resDict["v"]; exist {
+		item = &Item{Value: iField}
+		if iField, exist = resDict["k"]; exist {
+			if item.Key, typeOk = iField.(string); !typeOk {
+				goto ERROR
+			}
+			if item.Seq, typeOk = resDict["seq"].(int); !typeOk {
+				goto ERROR
+			}
+			if item.Sig, typeOk = resDict["sig"].(string); !typeOk {
+				goto ERROR
 			}
 		}
+		response.Item = item
+	}
+	return response, nil
+ERROR:
+	return nil, fmt.Errorf("%w: get", ErrInvalidResponse)
+}
+
+func UnserializePutResponse(transactionId string, resDict map[string]interface{}) (response *PutResponse, err error) {
+	var (
+		iField interface{}
+		exist bool
+		typeOk bool
+	)
+
+	response = &PutResponse{}
+	response.TransactionId = transactionId
+	response.Type = "r"
+
+	if iField, exist = resDict["id"]; !exist {
+		goto ERROR
+	}
+	if response.Id, typeOk = iField.(string); !typeOk {
+		goto ERROR
+	}
+	return response, nil
+ERROR:
+	return nil, fmt.Errorf("%w: put", ErrInvalidResponse)
+}
+
+func UnserializeSampleInfohashesResponse(transactionId string, resDict map[string]interface{}) (response *SampleInfohashesResponse, err error) {
+	var (
+		iField interface{}
+		exist bool
+		typeOk bool
+		nodes string
+		samples string
+	)
+
+	response = &SampleInfohashesResponse{}
+	response.TransactionId = transactionId
+	response.Type = "r"
+	response.Samples = make([]string, 0)
+	response.Nodes = make([]*CompactNode, 0)
+	response.Nodes6 = make([]*CompactNode, 0)
+
+	if iField, exist = resDict["id"]; !exist {
+		goto ERROR
+	}
+	if response.Id, typeOk = iField.(string); !typeOk {
+		goto ERROR
+	}
+
+	if iField, exist = resDict["interval"]; exist {
+		if response.Interval, typeOk = iField.(int); !typeOk {
+			goto ERROR
+		}
+		if response.Interval < 0 {
+			response.Interval = 0
+		} else if response.Interval > MAX_SAMPLE_INTERVAL {
+			response.Interval = MAX_SAMPLE_INTERVAL
+		}
+	}
+	if iField, exist = resDict["num"]; exist {
+		if response.Num, typeOk = iField.(int); !typeOk {
+			goto ERROR
+		}
+	}
+
+	// 20字节infohash的拼接
+	if iField, exist = resDict["samples"]; exist {
+		if samples, typeOk = iField.(string); !typeOk || len(samples) % 20 != 0 {
+			goto ERROR
+		}
+		for i := 0; i < len(samples); i += 20 {
+			response.Samples = append(response.Samples, samples[i:i + 20])
+		}
+	}
+
+	if iField, exist = resDict["nodes"]; exist {
+		if nodes, typeOk = iField.(string); !typeOk {
+			goto ERROR
+		}
+		if response.Nodes, err = UnserializeCompactNodes(nodes, COMPACT_NODE_SIZE); err != nil {
+			goto ERROR
+		}
+	}
+	if iField, exist = resDict["nodes6"]; exist {
+		if nodes, typeOk = iField.(string); !typeOk {
+			goto ERROR
+		}
+		if response.Nodes6, err = UnserializeCompactNodes(nodes, COMPACT_NODE6_SIZE); err != nil {
+			goto ERROR
+		}
 	}
 	return response, nil
 ERROR:
-	return nil, errors.New("invalid find_node response")
+	return nil, fmt.Errorf("%w: sample_infohashes", ErrInvalidResponse)
 }
 
-func UnserializeAnnouncePeerResponse(transactionId string, benDict map[string]interface{}) (response *AnnouncePeerResponse, err error) {
-	err = errors.New("not implement")
-	return
+func UnserializeAnnouncePeerResponse(transactionId string, resDict map[string]interface{}) (response *AnnouncePeerResponse, err error) {
+	var (
+		iField interface{}
+		exist bool
+		typeOk bool
+	)
+
+	response = &AnnouncePeerResponse{}
+	response.TransactionId = transactionId
+	response.Type = "r"
+
+	if iField, exist = resDict["id"]; !exist {
+		goto ERROR
+	}
+	if response.Id, typeOk = iField.(string); !typeOk {
+		goto ERROR
+	}
+	return response, nil
+ERROR:
+	return nil, fmt.Errorf("%w: announce_peer", ErrInvalidResponse)
 }
 
+// 序列化为26字节(IPv4)或38字节(IPv6)
 func (node *CompactNode) Serialize() (bytes []byte, err error) {
 	var (
-		addr *net.UDPAddr
+		peerInfo string
 	)
-	if addr, err = net.ResolveUDPAddr("udp", node.Address); err != nil {
+	if peerInfo, err = SerializePeerInfo(node.Address); err != nil {
 		return
 	}
-	bytes = make([]byte, 26)
+	bytes = make([]byte, 20 + len(peerInfo))
 	copy(bytes, node.Id)
-	copy(bytes[20:24], addr.IP)
-	binary.BigEndian.PutUint16(bytes[24:26], uint16(addr.Port))
+	copy(bytes[20:], peerInfo)
 	return bytes, nil
 }
 
+func serializeCompactNodes(nodes []*CompactNode) string {
+	var (
+		compactNodeBytes []byte
+		nodesBytes []byte = nil
+		err error
+	)
+	for _, compactNode := range nodes {
+		if compactNodeBytes, err = compactNode.Serialize(); err == nil {
+			nodesBytes = append(nodesBytes, compactNodeBytes...)
+		}
+	}
+	return string(nodesBytes)
+}
+
 func (response *PingResponse) Serialize() ([]byte, error){
 	resp := map[string]interface{}{}
 
@@ -301,74 +669,77 @@ func (response *PingResponse) Serialize() ([]byte, error){
 	resp["y"] = "r"
 
 	r := map[string]interface{}{}
-	r["id"] = MyNodeId()
+	r["id"] = response.Id
 
 	resp["r"] = r
+	if len(response.IP) != 0 {
+		resp["ip"] = response.IP
+	}
 	return Encode(resp)
 }
 
 func (response *FindNodeResponse) Serialize() (bytes []byte, err error){
 	var (
-		compactNode *CompactNode
-		compactNodeBytes []byte
 		resp = map[string]interface{}{}
 		r = map[string]interface{}{}
-		nodesBytes []byte = nil
 	)
 
 	resp["t"] = response.TransactionId
 	resp["y"] = "r"
 
-	r["id"] = MyNodeId()
-	for _, compactNode = range response.Nodes {
-		if compactNodeBytes, err = compactNode.Serialize(); err == nil {
-			nodesBytes = append(	nodesBytes, compactNodeBytes...)
-		}
+	r["id"] = response.Id
+	r["nodes"] = serializeCompactNodes(response.Nodes)
+	if len(response.Nodes6) > 0 {
+		r["nodes6"] = serializeCompactNodes(response.Nodes6)
 	}
-	r["nodes"] = string(nodesBytes)
 
 	resp["r"] = r
+	if len(response.IP) != 0 {
+		resp["ip"] = response.IP
+	}
 	return Encode(resp)
 }
 
 func (response *GetPeersResponse) Serialize() (bytes []byte, err error) {
 	var (
-		addr *net.UDPAddr
-		compactNode *CompactNode
-		compactNodeBytes []byte
 		resp = map[string]interface{}{}
 		r = map[string]interface{}{}
-		nodesBytes []byte = nil
-		peerInfos  = make([]string, 0)
+		peerInfos  = make([]interface{}, 0)
 		peerInfo string
+		address string
 	)
 	resp["t"] = response.TransactionId
 	resp["y"] = "r"
 
-	var compactPeerInfo [6]byte
-	for _, peerInfo = range response.Values {
-		if addr, err = net.ResolveUDPAddr("udp", peerInfo); err != nil {
+	for _, address = range response.Values {
+		if peerInfo, err = SerializePeerInfo(address); err != nil {
 			return
 		}
-		copy(compactPeerInfo[0:4], addr.IP)
-		binary.BigEndian.PutUint16(bytes[4:6], uint16(addr.Port))
-		peerInfos = append(peerInfos, string(compactPeerInfo[:]))
+		peerInfos = append(peerInfos, peerInfo)
 	}
 	if len(peerInfos) > 0 {
 		r["values"] = peerInfos
 	} else {
-		for _, compactNode = range response.Nodes {
-			if compactNodeBytes, err = compactNode.Serialize(); err == nil {
-				nodesBytes = append(	nodesBytes, compactNodeBytes...)
-			}
+		r["nodes"] = serializeCompactNodes(response.Nodes)
+		if len(response.Nodes6) > 0 {
+			r["nodes6"] = serializeCompactNodes(response.Nodes6)
 		}
-		r["nodes"] = string(nodesBytes)
 	}
 
-	r["id"] = MyNodeId()
-	r["token"] = GetTokenManager().GetToken()
+	if response.BFsd != nil {
+		r["BFsd"] = response.BFsd.String()
+	}
+	if response.BFpe != nil {
+		r["BFpe"] = response.BFpe.String()
+	}
+
+	r["id"] = response.Id
+	r["token"] = response.Token
 
 	resp["r"] = r
+	if len(response.IP) != 0 {
+		resp["ip"] = response.IP
+	}
 	return Encode(resp)
 }
 
@@ -379,9 +750,86 @@ func (response *AnnouncePeerResponse) Serialize() ([]byte, error) {
 	)
 	resp["t"] = response.TransactionId
 	resp["y"] = "r"
-	r["id"] = MyNodeId()
+	r["id"] = response.Id
+
+	resp["r"] = r
+	if len(response.IP) != 0 {
+		resp["ip"] = response.IP
+	}
+	return Encode(resp)
+}
+
+func (response *GetResponse) Serialize() (bytes []byte, err error) {
+	var (
+		resp = map[string]interface{}{}
+		r = map[string]interface{}{}
+	)
+	resp["t"] = response.TransactionId
+	resp["y"] = "r"
+
+	r["id"] = response.Id
+	r["token"] = response.Token
+	r["nodes"] = serializeCompactNodes(response.Nodes)
+	if len(response.Nodes6) > 0 {
+		r["nodes6"] = serializeCompactNodes(response.Nodes6)
+	}
+	if response.Item != nil {
+		r["v"] = response.Item.Value
+		if response.Item.IsMutable() {
+			r["k"] = response.Item.Key
+			r["seq"] = response.Item.Seq
+			r["sig"] = response.Item.Sig
+		}
+	}
+
+	resp["r"] = r
+	if len(response.IP) != 0 {
+		resp["ip"] = response.IP
+	}
+	return Encode(resp)
+}
+
+func (response *PutResponse) Serialize() ([]byte, error) {
+	var (
+		resp = map[string]interface{}{}
+		r = map[string]interface{}{}
+	)
+	resp["t"] = response.TransactionId
+	resp["y"] = "r"
+	r["id"] = response.Id
+
+	resp["r"] = r
+	if len(response.IP) != 0 {
+		resp["ip"] = response.IP
+	}
+	return Encode(resp)
+}
+
+func (response *SampleInfohashesResponse) Serialize() (bytes []byte, err error) {
+	var (
+		resp = map[string]interface{}{}
+		r = map[string]interface{}{}
+		samples = make([]byte, 0, len(response.Samples) * 20)
+	)
+	resp["t"] = response.TransactionId
+	resp["y"] = "r"
+
+	for _, infoHash := range response.Samples {
+		samples = append(samples, infoHash...)
+	}
+	r["id"] = response.Id
+	r["interval"] = response.Interval
+	r["num"] = response.Num
+	r["samples"] = string(samples)
+	r["nodes"] = serializeCompactNodes(response.Nodes)
+	if len(response.Nodes6) > 0 {
+		r["nodes6"] = serializeCompactNodes(response.Nodes6)
+	}
 
 	resp["r"] = r
+	if len(response.IP) != 0 {
+		resp["ip"] = response.IP
+	}
 	return Encode(resp)
 }
 
@@ -408,6 +856,12 @@ func (response *FindNodeResponse) String() string {
 			ret += "->" + node.String()
 		}
 	}
+	if len(response.Nodes6) != 0 {
+		ret += "Nodes6=\n"
+		for _, node := range response.Nodes6 {
+			ret += "->" + node.String()
+		}
+	}
 	ret += "---------------------\n"
 	return ret
 }
@@ -420,15 +874,54 @@ func (response *GetPeersResponse) String() string {
 	if len(response.Values) != 0 {
 		ret += "Values=\n"
 		for _, address := range response.Values {
-			ret += "->" + address
+			ret += "->" + address + "\n"
 		}
 	}
+	if response.BFsd != nil {
+		ret += "BFsd=" + strconv.Itoa(response.BFsd.Estimate()) + "\n"
+	}
+	if response.BFpe != nil {
+		ret += "BFpe=" + strconv.Itoa(response.BFpe.Estimate()) + "\n"
+	}
 	if len(response.Nodes) != 0 {
 		ret += "Nodes=\n"
 		for _, node := range response.Nodes {
 			ret += "->" + node.String()
 		}
 	}
+	if len(response.Nodes6) != 0 {
+		ret += "Nodes6=\n"
+		for _, node := range response.Nodes6 {
+			ret += "->" + node.String()
+		}
+	}
+	ret += "---------------------\n"
+	return ret
+}
+
+func (response *SampleInfohashesResponse) String() string {
+	ret := "\n---SampleInfohashesResponse---\n"
+	ret += "T=" + hex.EncodeToString([]byte(response.TransactionId)) + "\n"
+	ret += "Id=" + hex.EncodeToString([]byte(response.Id)) + "\n"
+	ret += "Interval=" + strconv.Itoa(response.Interval) + " Num=" + strconv.Itoa(response.Num) + "\n"
+	if len(response.Samples) != 0 {
+		ret += "Samples=\n"
+		for _, infoHash := range response.Samples {
+			ret += "->" + hex.EncodeToString([]byte(infoHash)) + "\n"
+		}
+	}
+	if len(response.Nodes) != 0 {
+		ret += "Nodes=\n"
+		for _, node := range response.Nodes {
+			ret += "->" + node.String()
+		}
+	}
+	if len(response.Nodes6) != 0 {
+		ret += "Nodes6=\n"
+		for _, node := range response.Nodes6 {
+			ret += "->" + node.String()
+		}
+	}
 	ret += "---------------------\n"
 	return ret
 }
@@ -455,13 +948,12 @@ func MyNodeId() string {
 	return myNodeId
 }
 
-// 生成请求唯一ID
-var myTransactionId int64 = 0
-var initTransactionId sync.Once
-
+// 生成请求ID: 每个请求独立随机, 远端无法由见过的ID推测其他请求的ID来伪造应答
 func GenTransactionId() string {
-	initTransactionId.Do(func() {
-		myTransactionId = time.Now().UnixNano()
-	})
-	return strconv.Itoa(int(atomic.AddInt64(&myTransactionId, 1)))
+	randBytes := make([]byte, TRANSACTION_ID_SIZE)
+	for {
+		if _, err := rand.Read(randBytes); err == nil {
+			return string(randBytes)
+		}
+	}
 }
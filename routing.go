@@ -2,7 +2,6 @@ package dht
 
 import (
 	"time"
-	"math/big"
 	"sync"
 	"sort"
 )
@@ -10,27 +9,42 @@ import (
 const (
  	KNODES = 8	// 每个桶保存8个节点
  	MAX_FAIL_TIMES = 3 // 3次连续fail则标记bad
+ 	MAX_REPLACEMENTS = 8 // 每个桶的候选节点上限
+ 	QUESTIONABLE_TIME = 15 * 60 // 15分钟不活跃则标记questionable
+ 	BUCKET_REFRESH_TIME = 15 * 60 // 15分钟没有变化的桶需要刷新
 
  	// 节点状态
 	NODE_STATUS_GOOD = 1
 	NODE_STATUS_BAD = 2
+	NODE_STATUS_QUESTIONABLE = 3
 )
 
 type Node struct {
+	id NodeID
 	info *CompactNode	// 节点地址
 	lastActive int64	// 上次活跃时间
 	failTimes int // 连续访问失败的次数, 超过3次就标记为bad
 	status int // 状态: good, bad, questionable
+	ip string // 用于IP限制, 不受限制的地址为空
+	subnet string
+	secure bool // ID是否符合BEP 42
 }
 
+// 第i个桶保存与自己共同前缀恰好为i位的节点, 最后一个桶保存共同前缀不少于i位的节点(包含自己的范围)
 type Bucket struct {
-	nodes map[string]*Node
-	min, max *big.Int
+	nodes map[NodeID]*Node
 	lastActive int64
+	replacements []*CompactNode // 桶满时最近见过的候选节点, 越靠后越新
+	limiter *ipLimiter // 所属路由表的IP统计
 }
 
+// 按与自己的共同前缀长度索引的路由表, 查找桶为O(1)
 type RoutingTable struct {
+	myId string // 本节点ID
+	self NodeID
 	buckets []*Bucket
+	limiter *ipLimiter
+	securePolicy int // 对不符合BEP 42的节点的处理
 	mutex sync.Mutex
 }
 
@@ -48,49 +62,40 @@ func (closest ClosestNodes) Swap(i, j int) {
 }
 
 func (closest ClosestNodes) Less(i, j int) bool {
-	leftId := nodeId2Int(closest.nodes[i].Id)
-	rightId := nodeId2Int(closest.nodes[j].Id)
-	targetId := nodeId2Int(closest.target)
+	return compareDistance(closest.target, closest.nodes[i].Id, closest.nodes[j].Id) < 0
+}
 
-	// 计算异或距离, 比较大小
-	cmp := new(big.Int).Xor(leftId, targetId).Cmp( new(big.Int).Xor(rightId, targetId) )
-	return cmp < 0
+// 比较left, right到target的异或距离: -1更近, 0相等, 1更远
+func compareDistance(target string, left string, right string) int {
+	return CompareDistance(NewNodeID(target), NewNodeID(left), NewNodeID(right))
 }
 
-func newBucket(min, max *big.Int) (bucket *Bucket) {
+func newBucket(limiter *ipLimiter) (bucket *Bucket) {
 	bucket = &Bucket{}
 
-	bucket.min = min
-	bucket.max = max
-	bucket.nodes = make(map[string]*Node)
+	bucket.limiter = limiter
+	bucket.nodes = make(map[NodeID]*Node)
 	bucket.lastActive = time.Now().Unix()
 	return
 }
 
-func nodeId2Int(nodeId string) *big.Int {
-	return new(big.Int).SetBytes([]byte(nodeId))
-}
-
 func (bucket *Bucket) size() int {
 	return len(bucket.nodes)
 }
 
-func (bucket *Bucket) inRange(nodeId string) bool {
-	intId := nodeId2Int(nodeId)
-	return intId.Cmp(bucket.min) >= 0 && intId.Cmp(bucket.max) < 0
-}
-
 func (bucket *Bucket) insertNode(nodeInfo *CompactNode) bool {
 	var (
 		node *Node
 		exist bool
+		id = NewNodeID(nodeInfo.Id)
 	)
-	if node, exist = bucket.nodes[nodeInfo.Id]; exist {
+	if node, exist = bucket.nodes[id]; exist {
+		bucket.deleteNode(id)
 		goto REPLACE
 	}
 	for nodeId, node := range bucket.nodes {
 		if node.status == NODE_STATUS_BAD {
-			delete(bucket.nodes, nodeId) // 虽然是bad, 但删1个就好了
+			bucket.deleteNode(nodeId) // 虽然是bad, 但删1个就好了
 			break
 		}
 	}
@@ -99,20 +104,86 @@ func (bucket *Bucket) insertNode(nodeInfo *CompactNode) bool {
 	}
 REPLACE:
 	node = &Node{}
+	node.id = id
 	node.info = nodeInfo
 	node.status = NODE_STATUS_GOOD
 	node.lastActive = time.Now().Unix()
 	node.failTimes = 0
-	bucket.nodes[nodeInfo.Id] = node
+	node.ip, node.subnet = addressKeys(nodeInfo.Address)
+	node.secure = IsSecureNodeId(nodeInfo.Id, addressIP(nodeInfo.Address))
+	bucket.removeReplacement(nodeInfo.Id)
+	bucket.nodes[id] = node
+	bucket.limiter.add(node)
+	bucket.limiter.index(nodeInfo.Address, id)
 	bucket.lastActive = time.Now().Unix()
 	return true
 }
 
-func rootBucket() (root *Bucket) {
-	minId := big.NewInt(0)
-	maxId := new(big.Int).Exp(big.NewInt(2), big.NewInt(160), nil)
-	root = newBucket(minId, maxId)
-	root.insertNode(&CompactNode{"", MyNodeId()})
+func (bucket *Bucket) deleteNode(id NodeID) {
+	if node, exist := bucket.nodes[id]; exist {
+		if node.status != NODE_STATUS_BAD { // bad节点已经不计入IP统计
+			bucket.limiter.remove(node)
+		}
+		bucket.limiter.unindex(node.info.Address, id)
+		delete(bucket.nodes, id)
+	}
+}
+
+// 淘汰一个ID不符合BEP 42的节点, 给符合的节点让位
+func (bucket *Bucket) evictInsecure() bool {
+	for nodeId, node := range bucket.nodes {
+		if !node.secure {
+			bucket.deleteNode(nodeId)
+			return true
+		}
+	}
+	return false
+}
+
+// 桶满时记录候选节点, 已存在则移到末尾, 超过上限淘汰最旧的
+func (bucket *Bucket) addReplacement(nodeInfo *CompactNode) {
+	bucket.removeReplacement(nodeInfo.Id)
+	bucket.replacements = append(bucket.replacements, nodeInfo)
+	if _, exist := bucket.limiter.addresses[nodeInfo.Address]; !exist { // 地址相同时优先索引桶内节点
+		bucket.limiter.index(nodeInfo.Address, NewNodeID(nodeInfo.Id))
+	}
+	if len(bucket.replacements) > MAX_REPLACEMENTS {
+		bucket.limiter.unindex(bucket.replacements[0].Address, NewNodeID(bucket.replacements[0].Id))
+		bucket.replacements = bucket.replacements[1:]
+	}
+}
+
+func (bucket *Bucket) removeReplacement(nodeId string) {
+	for i, replacement := range bucket.replacements {
+		if replacement.Id == nodeId {
+			bucket.limiter.unindex(replacement.Address, NewNodeID(nodeId))
+			bucket.replacements = append(bucket.replacements[:i], bucket.replacements[i + 1:]...)
+			return
+		}
+	}
+}
+
+// 用最新的, 满足IP限制的候选节点替换bad节点
+func (bucket *Bucket) promoteReplacement(badId NodeID) bool {
+	for i := len(bucket.replacements) - 1; i >= 0; i-- {
+		replacement := bucket.replacements[i]
+		if !bucket.limiter.allow(bucket, NewNodeID(replacement.Id), replacement.Address) {
+			continue
+		}
+		bucket.removeReplacement(replacement.Id)
+		bucket.deleteNode(badId)
+		return bucket.insertNode(replacement)
+	}
+	return false
+}
+
+// 以myId为中心的路由表
+func NewRoutingTable(myId string) (rt *RoutingTable) {
+	rt = &RoutingTable{}
+	rt.myId = myId
+	rt.self = NewNodeID(myId)
+	rt.limiter = newIPLimiter()
+	rt.buckets = append(rt.buckets, newBucket(rt.limiter))
 	return
 }
 
@@ -122,64 +193,77 @@ var initRoutingTableOnce sync.Once
 
 func GetRoutingTable() (*RoutingTable) {
 	initRoutingTableOnce.Do(func () {
-		routingTable = &RoutingTable{}
-		routingTable.buckets = append(routingTable.buckets, rootBucket())
+		routingTable = NewRoutingTable(MyNodeId())
 	})
 	return routingTable
 }
 
-func (rt *RoutingTable) splitBucket(idx int) {
+// 分裂最后一个桶: 共同前缀恰好为idx位的留下, 更长的进入新的最后一个桶
+func (rt *RoutingTable) splitBucket() {
+	idx := len(rt.buckets) - 1
 	toSplit := rt.buckets[idx]
+	nextBucket := newBucket(rt.limiter)
 
-	sumRange := new(big.Int).Add(toSplit.min, toSplit.max)
-	mid := new(big.Int).Div(sumRange, big.NewInt(2))
-
-	rightBucket := newBucket(mid, toSplit.max)
-	toSplit.max = mid
-
-	// 原桶分裂成2个新桶
 	for nodeId, node := range toSplit.nodes {
-		if !toSplit.inRange(nodeId) {
+		if rt.self.PrefixLen(nodeId) > idx {
 			delete(toSplit.nodes, nodeId)
-			rightBucket.nodes[nodeId] = node
+			nextBucket.nodes[nodeId] = node
+		}
+	}
+	nextBucket.lastActive = toSplit.lastActive
+
+	// 候选节点按前缀分开
+	replacements := toSplit.replacements
+	toSplit.replacements = nil
+	for _, replacement := range replacements {
+		if rt.self.PrefixLen(NewNodeID(replacement.Id)) > idx {
+			nextBucket.replacements = append(nextBucket.replacements, replacement)
+		} else {
+			toSplit.replacements = append(toSplit.replacements, replacement)
 		}
 	}
-	rightBucket.lastActive = toSplit.lastActive
 
-	// 插入分裂后的桶
-	rt.buckets = append(rt.buckets, nil) // 扩容
-	insertIdx := idx + 1
-	copy(rt.buckets[insertIdx + 1:], rt.buckets[insertIdx:])
-	rt.buckets[insertIdx] = rightBucket
+	rt.buckets = append(rt.buckets, nextBucket)
 }
 
-func (rt *RoutingTable) findBucket(nodeId string) int {
-	for i := 0; i < len(rt.buckets); i++ {
-		if !rt.buckets[i].inRange(nodeId) {
-			continue
-		}
-		return i
+// 节点所在的桶: 共同前缀长度, 超出则为最后一个桶
+func (rt *RoutingTable) findBucket(nodeId NodeID) int {
+	idx := rt.self.PrefixLen(nodeId)
+	if idx >= len(rt.buckets) {
+		idx = len(rt.buckets) - 1
 	}
-	return -1
+	return idx
 }
 
 func (rt *RoutingTable) insertNode(nodeInfo *CompactNode) bool {
-	if nodeInfo.Id == MyNodeId() {
+	if nodeInfo.Id == rt.myId {
 		return true
 	}
-
-	idx := rt.findBucket(nodeInfo.Id)
-	if idx < 0 {
-		return false // never reach
+	if len(nodeInfo.Id) != len(rt.self) {
+		return false
 	}
 
+	id := NewNodeID(nodeInfo.Id)
+	idx := rt.findBucket(id)
+	secure := rt.securePolicy == SECURE_ID_IGNORE || IsSecureNodeId(nodeInfo.Id, addressIP(nodeInfo.Address))
+	if !secure && rt.securePolicy == SECURE_ID_ENFORCE {
+		return false
+	}
+	if !rt.limiter.allow(rt.buckets[idx], id, nodeInfo.Address) { // 同一IP或子网的节点过多, 也不进入候选
+		return false
+	}
 	if rt.buckets[idx].insertNode(nodeInfo) { // bucket没满插入成功
 		return true
 	}
-	if !rt.buckets[idx].inRange(MyNodeId()) { // bucket不包含自身,无法分裂
+	// 只有最后一个桶包含自身, 可以分裂; 160位都相同的只有自己, 不会无限分裂
+	if idx != len(rt.buckets) - 1 || len(rt.buckets) >= len(rt.self) * 8 {
+		if secure && rt.securePolicy == SECURE_ID_PREFER && rt.buckets[idx].evictInsecure() {
+			return rt.buckets[idx].insertNode(nodeInfo)
+		}
+		rt.buckets[idx].addReplacement(nodeInfo) // 无法分裂, 留作候选
 		return false
 	}
-	rt.splitBucket(idx)
+	rt.splitBucket()
 	return rt.insertNode(nodeInfo)
 }
 
@@ -189,32 +273,71 @@ func (rt *RoutingTable) InsertNode(nodeInfo *CompactNode) bool {
 	return rt.insertNode(nodeInfo)
 }
 
+// 桶的数量, 节点数见NodeCount, 各桶详情见Snapshot
 func (rt *RoutingTable) Size() int {
 	rt.mutex.Lock()
 	defer rt.mutex.Unlock()
 	return len(rt.buckets)
 }
 
+// 非bad节点的数量
+func (rt *RoutingTable) NodeCount() (count int) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+
+	for _, bucket := range rt.buckets {
+		for _, node := range bucket.nodes {
+			if node.status != NODE_STATUS_BAD {
+				count++
+			}
+		}
+	}
+	return
+}
+
 func (rt *RoutingTable) Fail(nodeId string) {
 	rt.mutex.Lock()
 	defer rt.mutex.Unlock()
 
-	if nodeId == MyNodeId() {
+	if nodeId == rt.myId {
 		return
 	}
 
-	idx := rt.findBucket(nodeId)
-	if idx < 0 {
+	id := NewNodeID(nodeId)
+	idx := rt.findBucket(id)
+	if node, exist := rt.buckets[idx].nodes[id]; exist {
+		rt.failNode(idx, node)
+	} else {
+		rt.buckets[idx].removeReplacement(nodeId) // 失败的候选直接丢弃
+	}
+}
+
+// 按地址累计失败次数, 用于只知道请求地址的场景(例如RPC超时)
+func (rt *RoutingTable) FailAddress(address string) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+
+	id, exist := rt.limiter.addresses[address]
+	if !exist {
 		return
 	}
+	idx := rt.findBucket(id)
+	if node, exist := rt.buckets[idx].nodes[id]; exist && node.info.Address == address {
+		rt.failNode(idx, node)
+	} else {
+		rt.buckets[idx].removeReplacement(id.Binary()) // 失败的候选直接丢弃
+	}
+}
 
-	if node, exist := rt.buckets[idx].nodes[nodeId]; exist {
-		if node.status != NODE_STATUS_BAD {
-			node.failTimes++
-			if node.failTimes >= MAX_FAIL_TIMES {
-				node.status = NODE_STATUS_BAD
-			}
-		}
+func (rt *RoutingTable) failNode(idx int, node *Node) {
+	if node.status == NODE_STATUS_BAD {
+		return
+	}
+	node.failTimes++
+	if node.failTimes >= MAX_FAIL_TIMES {
+		node.status = NODE_STATUS_BAD
+		rt.limiter.remove(node)
+		rt.buckets[idx].promoteReplacement(node.id) // 有候选则立即替换
 	}
 }
 
@@ -223,67 +346,142 @@ func (rt *RoutingTable) FindNode(nodeId string) *CompactNode {
 	defer rt.mutex.Unlock()
 
 	// 永远不返回自己
-	if nodeId == MyNodeId() {
-		return nil
-	}
-
-	idx := rt.findBucket(nodeId)
-	if idx < 0 {
+	if nodeId == rt.myId {
 		return nil
 	}
 
-	nodes := rt.buckets[idx].nodes
-	if node, exist := nodes[nodeId]; exist {
+	id := NewNodeID(nodeId)
+	if node, exist := rt.buckets[rt.findBucket(id)].nodes[id]; exist {
 		return node.info
 	}
 	return nil
 }
 
-func (rt *RoutingTable) ClosestNodes(nodeId string) (nodes []*CompactNode) {
+// 整个路由表中距离target最近的k个good节点, 不包含自己
+//
+// 设target与自己的共同前缀为c位: 第c个桶的节点与target共享前c+1位, 距离最近;
+// 其次是c之后的所有桶, 它们与target在第c位不同; 再其次是c-1, c-2, ..., 0号桶, 越小越远.
+// 按这个顺序逐组收集, 凑够k个后只需对已收集的节点排序
+func (rt *RoutingTable) ClosestNodes(target string, k int) (nodes []*CompactNode) {
 	nodes = make([]*CompactNode, 0)
 
 	rt.mutex.Lock()
 	defer rt.mutex.Unlock()
 
-	idx := rt.findBucket(nodeId)
-	if idx < 0 {
-		return
-	}
-
-	for _, node := range rt.buckets[idx].nodes {
-		if node.info.Id != nodeId {
-			nodes = append(nodes, node.info)
+	collect := func(bucket *Bucket) {
+		for _, node := range bucket.nodes {
+			if node.status == NODE_STATUS_GOOD {
+				nodes = append(nodes, node.info)
+			}
 		}
 	}
 
-	// 不足8个, 找周边的bucket
-	if len(nodes) < KNODES {
-		leftIdx := idx - 1
-		rightIdx := idx + 1
-		for len(nodes) < KNODES && (leftIdx >= 0 || rightIdx < len(rt.buckets)){ // 从左边和右边的邻居桶补一些进来
-			if leftIdx >= 0 {
-				for _, node := range rt.buckets[leftIdx].nodes {
-					nodes = append(nodes, node.info)
-				}
-			}
-			if rightIdx < len(rt.buckets) {
-				for _, node := range rt.buckets[rightIdx].nodes {
-					nodes = append(nodes, node.info)
-				}
-			}
-			leftIdx--
-			rightIdx++
+	last := len(rt.buckets) - 1
+	idx := rt.findBucket(NewNodeID(target))
+	collect(rt.buckets[idx])
+	if idx < last && len(nodes) < k {
+		for i := idx + 1; i <= last; i++ {
+			collect(rt.buckets[i])
 		}
 	}
+	for i := idx - 1; i >= 0 && len(nodes) < k; i-- {
+		collect(rt.buckets[i])
+	}
 
 	// 按距离排序
 	closestNodes := ClosestNodes{}
-	closestNodes.target = nodeId
+	closestNodes.target = target
 	closestNodes.nodes = nodes
 	sort.Sort(closestNodes)
-	// 取最近的8个
-	if len(nodes) > KNODES {
-		nodes = nodes[:KNODES]
+	// 取最近的k个
+	if len(nodes) > k {
+		nodes = nodes[:k]
+	}
+	return
+}
+
+// 超过QUESTIONABLE_TIME不活跃的good节点标记为questionable, 返回所有questionable节点, 需要ping确认
+func (rt *RoutingTable) QuestionableNodes() (nodes []*CompactNode) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+
+	now := time.Now().Unix()
+	nodes = make([]*CompactNode, 0)
+	for _, bucket := range rt.buckets {
+		for _, node := range bucket.nodes {
+			if node.status == NODE_STATUS_GOOD && now - node.lastActive >= QUESTIONABLE_TIME {
+				node.status = NODE_STATUS_QUESTIONABLE
+			}
+			if node.status == NODE_STATUS_QUESTIONABLE {
+				nodes = append(nodes, node.info)
+			}
+		}
 	}
 	return
-}
\ No newline at end of file
+}
+
+// 超过BUCKET_REFRESH_TIME没有变化的桶, 各返回一个范围内的随机ID用于find_node刷新, 同时视为已刷新
+func (rt *RoutingTable) RefreshTargets() (targets []string) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+
+	now := time.Now().Unix()
+	targets = make([]string, 0)
+	for idx, bucket := range rt.buckets {
+		if now - bucket.lastActive >= BUCKET_REFRESH_TIME {
+			bucket.lastActive = now
+			targets = append(targets, rt.randomIdInBucket(idx).Binary())
+		}
+	}
+	return
+}
+
+// 第idx个桶范围内的随机ID
+func (rt *RoutingTable) randomIdInBucket(idx int) NodeID {
+	if idx == len(rt.buckets) - 1 {
+		return RandomNodeID(rt.self, idx)
+	}
+	return RandomNodeID(rt.self.FlipBit(idx), idx + 1)
+}
+
+// 对不符合BEP 42的节点的处理策略, 已在路由表中的节点不受影响
+func (rt *RoutingTable) SetSecureIdPolicy(policy int) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	rt.securePolicy = policy
+}
+
+// 更换本节点ID: 以新ID为中心重新分桶, 保留非bad节点的状态与候选节点
+func (rt *RoutingTable) SetId(myId string) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+
+	oldBuckets := rt.buckets
+	rt.myId = myId
+	rt.self = NewNodeID(myId)
+	limits := rt.limiter.limits
+	rt.limiter = newIPLimiter()
+	rt.limiter.limits = limits
+	rt.buckets = []*Bucket{newBucket(rt.limiter)}
+
+	for _, bucket := range oldBuckets {
+		for _, node := range bucket.nodes {
+			if node.status == NODE_STATUS_BAD || !rt.insertNode(node.info) {
+				continue
+			}
+			idx := rt.findBucket(node.id)
+			if inserted, exist := rt.buckets[idx].nodes[node.id]; exist {
+				inserted.status = node.status
+				inserted.lastActive = node.lastActive
+				inserted.failTimes = node.failTimes
+			}
+		}
+	}
+	for _, bucket := range oldBuckets {
+		for _, replacement := range bucket.replacements {
+			if replacement.Id != myId {
+				rt.buckets[rt.findBucket(NewNodeID(replacement.Id))].addReplacement(replacement)
+			}
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package dht
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	PEER_EVENT_ANNOUNCE = "announce" // 收到announce_peer, IP:Port是下载地址
+	PEER_EVENT_LOOKUP = "lookup" // 收到get_peers, 没有IP:Port, 查询者见Source, NodeId
+)
+
+// 收到announce_peer或get_peers时产生的事件
+type PeerEvent struct {
+	Type string // announce, lookup
+	InfoHash string // 20字节infohash
+	IP net.IP // peer地址, lookup事件为空
+	Port int // peer端口, lookup事件为0
+	NodeId string // 来源节点ID
+	Source *net.UDPAddr // 来源节点地址
+	ImpliedPort bool // 端口是否取自UDP来源端口
+	Seed bool // announce时声明为做种者 (BEP 33)
+	Time time.Time // 收到的时间
+}
+
+// 接收PeerEvent, 在处理请求的协程中被并发调用, 实现不应阻塞
+type PeerSink interface {
+	HandlePeer(event *PeerEvent)
+}
+
+func (event *PeerEvent) Magnet() string {
+	return "magnet:?xt=urn:btih:" + hex.EncodeToString([]byte(event.InfoHash))
+}
+
+// 打印announce的磁力链接到标准输出, 格式为: 磁力链接 IP : 端口
+type StdoutSink struct {
+	Lookups bool // 同时打印get_peers查询事件, 格式为: lookup 磁力链接 来源地址
+}
+
+func (sink *StdoutSink) HandlePeer(event *PeerEvent) {
+	if event.Type == PEER_EVENT_LOOKUP {
+		if sink.Lookups {
+			fmt.Println(event.Type, event.Magnet(), event.Source)
+		}
+		return
+	}
+	fmt.Println(event.Magnet(), event.IP, ":", event.Port)
+}
+
+// 投递到channel, channel满则丢弃
+type ChanSink struct {
+	C chan *PeerEvent
+}
+
+func NewChanSink(size int) *ChanSink {
+	return &ChanSink{C: make(chan *PeerEvent, size)}
+}
+
+func (sink *ChanSink) HandlePeer(event *PeerEvent) {
+	select {
+	case sink.C <- event:
+	default:
+	}
+}
+
+// 以JSON lines格式追加写入文件
+type JSONFileSink struct {
+	mutex sync.Mutex
+	file *os.File
+	encoder *json.Encoder
+}
+
+type jsonPeerEvent struct {
+	Type string `json:"type"`
+	InfoHash string `json:"info_hash"`
+	IP string `json:"ip"`
+	Port int `json:"port"`
+	NodeId string `json:"node_id"`
+	Source string `json:"source"`
+	ImpliedPort bool `json:"implied_port"`
+	Seed bool `json:"seed"`
+	Time int64 `json:"time"`
+}
+
+func NewJSONFileSink(path string) (sink *JSONFileSink, err error) {
+	sink = &JSONFileSink{}
+	if sink.file, err = os.OpenFile(path, os.O_CREATE | os.O_WRONLY | os.O_APPEND, 0644); err != nil {
+		return nil, err
+	}
+	sink.encoder = json.NewEncoder(sink.file)
+	return sink, nil
+}
+
+func (sink *JSONFileSink) HandlePeer(event *PeerEvent) {
+	record := &jsonPeerEvent{
+		Type: event.Type,
+		InfoHash: hex.EncodeToString([]byte(event.InfoHash)),
+		Port: event.Port,
+		NodeId: hex.EncodeToString([]byte(event.NodeId)),
+		Source: event.Source.String(),
+		ImpliedPort: event.ImpliedPort,
+		Seed: event.Seed,
+		Time: event.Time.Unix(),
+	}
+	if event.IP != nil {
+		record.IP = event.IP.String()
+	}
+
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	sink.encoder.Encode(record)
+}
+
+func (sink *JSONFileSink) Close() error {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	return sink.file.Close()
+}
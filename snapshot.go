@@ -0,0 +1,145 @@
+package dht
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 路由表中单个节点的快照
+type NodeSnapshot struct {
+	Id NodeID `json:"id"`
+	Address string `json:"address"`
+	Status string `json:"status"` // good, bad, questionable
+	FailTimes int `json:"fail_times"`
+	LastActive time.Time `json:"last_active"`
+}
+
+// 单个桶的快照, 桶内节点ID都以Prefix开头, 位于[Min, Max]之间
+type BucketSnapshot struct {
+	Index int `json:"index"`
+	Prefix string `json:"prefix"` // 二进制位串, 如"0110"
+	Min NodeID `json:"min"`
+	Max NodeID `json:"max"`
+	LastActive time.Time `json:"last_active"`
+	Nodes []*NodeSnapshot `json:"nodes"`
+	Replacements int `json:"replacements"` // 候选节点数
+}
+
+// 路由表快照, 用于排查连通性问题
+type RoutingTableSnapshot struct {
+	Id NodeID `json:"id"`
+	NodeCount int `json:"node_count"` // 非bad节点数
+	Buckets []*BucketSnapshot `json:"buckets"`
+}
+
+// 运行中节点的IPv4与IPv6路由表快照
+type DHTSnapshot struct {
+	Id NodeID `json:"id"`
+	Time time.Time `json:"time"` // 生成快照的时间
+	IPv4 *RoutingTableSnapshot `json:"ipv4"`
+	IPv6 *RoutingTableSnapshot `json:"ipv6"`
+}
+
+func nodeStatusName(status int) string {
+	switch status {
+	case NODE_STATUS_GOOD:
+		return "good"
+	case NODE_STATUS_BAD:
+		return "bad"
+	case NODE_STATUS_QUESTIONABLE:
+		return "questionable"
+	}
+	return "unknown"
+}
+
+// 路由表当前状态的拷贝, 不影响路由表本身
+func (rt *RoutingTable) Snapshot() (snapshot *RoutingTableSnapshot) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+
+	snapshot = &RoutingTableSnapshot{Id: rt.self}
+	for idx, bucket := range rt.buckets {
+		prefix, prefixLen := rt.self, idx
+		if idx != len(rt.buckets) - 1 {
+			prefix, prefixLen = rt.self.FlipBit(idx), idx + 1
+		}
+
+		bucketSnapshot := &BucketSnapshot{
+			Index: idx,
+			Prefix: prefixBits(prefix, prefixLen),
+			Min: prefixRange(prefix, prefixLen, 0),
+			Max: prefixRange(prefix, prefixLen, 0xff),
+			LastActive: time.Unix(bucket.lastActive, 0),
+			Nodes: make([]*NodeSnapshot, 0, len(bucket.nodes)),
+			Replacements: len(bucket.replacements),
+		}
+		for _, node := range bucket.nodes {
+			bucketSnapshot.Nodes = append(bucketSnapshot.Nodes, &NodeSnapshot{
+				Id: node.id,
+				Address: node.info.Address,
+				Status: nodeStatusName(node.status),
+				FailTimes: node.failTimes,
+				LastActive: time.Unix(node.lastActive, 0),
+			})
+			if node.status != NODE_STATUS_BAD {
+				snapshot.NodeCount++
+			}
+		}
+		sort.Slice(bucketSnapshot.Nodes, func(i, j int) bool {
+			return bucketSnapshot.Nodes[i].Id.Compare(bucketSnapshot.Nodes[j].Id) < 0
+		})
+		snapshot.Buckets = append(snapshot.Buckets, bucketSnapshot)
+	}
+	return
+}
+
+// 节点当前两个路由表的快照, 包含失败次数, bad状态与候选节点
+func (node *DHTNode) Snapshot() *DHTSnapshot {
+	return &DHTSnapshot{
+		Id: NewNodeID(node.Id()),
+		Time: time.Now(),
+		IPv4: node.routingTable.Snapshot(),
+		IPv6: node.routingTable6.Snapshot(),
+	}
+}
+
+// 以JSON格式写入文件, 供其他进程(例如crawler dump)查看运行中的节点
+func (snapshot *DHTSnapshot) Save(path string) error {
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+func LoadDHTSnapshot(path string) (snapshot *DHTSnapshot, err error) {
+	var data []byte
+	if data, err = ioutil.ReadFile(path); err != nil {
+		return nil, err
+	}
+	snapshot = &DHTSnapshot{}
+	if err = json.Unmarshal(data, snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// 前prefixLen位的位串
+func prefixBits(prefix NodeID, prefixLen int) string {
+	var bits strings.Builder
+	for i := 0; i < prefixLen; i++ {
+		bits.WriteByte(byte('0' + prefix.Bit(i)))
+	}
+	return bits.String()
+}
+
+// 保留前prefixLen位, 其余位填充fill
+func prefixRange(prefix NodeID, prefixLen int, fill byte) (nodeId NodeID) {
+	for i := range nodeId {
+		nodeId[i] = fill
+	}
+	return nodeId.WithPrefix(prefix, prefixLen)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"github.com/owenliang/dht"
+
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+)
+
+// BEP 42规范中的测试向量, r为ID的最后一个字节
+var vectors = []struct {
+	ip string
+	id string
+}{
+	{"124.31.75.21", "5fbfbff10c5d6a4ec8a88e4c6ab4c28b95eee401"},
+	{"21.75.31.124", "5a3ce9c14e7a08645677bbd1cfe7d8f956d53256"},
+	{"65.23.51.170", "a5d43220bc8f112a3d426c84764f8c2a1150e616"},
+	{"84.124.73.14", "1b0321dd1bb1fe518101ceef99462b947a01ff41"},
+	{"43.213.53.83", "e56f6cbf5b7c4be0237986d5243b87aa6d51305a"},
+}
+
+func check(name string, actual interface{}, expect interface{}) {
+	if actual != expect {
+		fmt.Println("FAIL", name, "actual", actual, "expect", expect)
+		os.Exit(1)
+	}
+}
+
+// 前21位是否相同
+func samePrefix(a string, b string) bool {
+	return a[0] == b[0] && a[1] == b[1] && a[2] & 0xf8 == b[2] & 0xf8
+}
+
+func main()  {
+	for i, vector := range vectors {
+		ip := net.ParseIP(vector.ip)
+		raw, _ := hex.DecodeString(vector.id)
+		id := string(raw)
+
+		check(vector.ip + " spec id", dht.IsSecureNodeId(id, ip), true)
+
+		// 前21位任意一位不同都不相符, 之后的位不影响
+		for bit := 0; bit < 21; bit++ {
+			flipped := []byte(id)
+			flipped[bit / 8] ^= 0x80 >> uint(bit % 8)
+			check(fmt.Sprintf("%s flip bit %d", vector.ip, bit), dht.IsSecureNodeId(string(flipped), ip), false)
+		}
+		tail := []byte(id)
+		tail[2] ^= 0x07
+		tail[10] ^= 0xff
+		check(vector.ip + " flip tail", dht.IsSecureNodeId(string(tail), ip), true)
+
+		// r的低3位参与计算
+		other := []byte(id)
+		other[19] ^= 0x01
+		check(vector.ip + " flip r", dht.IsSecureNodeId(string(other), ip), false)
+
+		// 换成另一个向量的IP不相符
+		otherIP := net.ParseIP(vectors[(i + 1) % len(vectors)].ip)
+		check(vector.ip + " other ip", dht.IsSecureNodeId(id, otherIP), false)
+
+		// 生成的ID总是相符, r相同时前21位与规范一致
+		matched := false
+		for n := 0; n < 1000; n++ {
+			gen := dht.GenSecureNodeId(ip)
+			check(vector.ip + " gen secure", dht.IsSecureNodeId(gen, ip), true)
+			if gen[19] & 0x7 == id[19] & 0x7 {
+				check(vector.ip + " gen prefix", samePrefix(gen, id), true)
+				matched = true
+			}
+		}
+		check(vector.ip + " gen same r", matched, true)
+	}
+
+	// 局域网地址不要求符合, 长度不对的ID总是不相符
+	check("local ip", dht.IsSecureNodeId(dht.GenNodeId(), net.ParseIP("192.168.1.1")), true)
+	check("loopback", dht.IsSecureNodeId(dht.GenNodeId(), net.ParseIP("127.0.0.1")), true)
+	check("short id", dht.IsSecureNodeId("abc", net.ParseIP(vectors[0].ip)), false)
+	fmt.Println("PASS")
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"github.com/owenliang/dht"
+
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+)
+
+// 与myId共享前prefixLen位的随机ID, 让路由表充分分裂
+func idWithPrefix(myId string, prefixLen int) string {
+	id := []byte(dht.GenNodeId())
+	for i := 0; i < prefixLen; i++ {
+		mask := byte(0x80 >> uint(i % 8))
+		id[i / 8] = id[i / 8] &^ mask | myId[i / 8] & mask
+	}
+	// 第prefixLen位与myId相反
+	if prefixLen < 160 {
+		mask := byte(0x80 >> uint(prefixLen % 8))
+		id[prefixLen / 8] = id[prefixLen / 8] &^ mask | ^myId[prefixLen / 8] & mask
+	}
+	return string(id)
+}
+
+func xorDistance(a, b string) []byte {
+	distance := make([]byte, 20)
+	for i := 0; i < 20; i++ {
+		distance[i] = a[i] ^ b[i]
+	}
+	return distance
+}
+
+// 暴力计算: 所有仍在表中且没有被标记失败的节点, 按异或距离排序取前k个
+func bruteForce(rt *dht.RoutingTable, candidates []string, failed map[string]bool, target string, k int) []string {
+	ids := make([]string, 0)
+	for _, id := range candidates {
+		if !failed[id] && rt.FindNode(id) != nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return bytes.Compare(xorDistance(ids[i], target), xorDistance(ids[j], target)) < 0
+	})
+	if len(ids) > k {
+		ids = ids[:k]
+	}
+	return ids
+}
+
+func main()  {
+	for round := 0; round < 200; round++ {
+		myId := dht.GenNodeId()
+		rt := dht.NewRoutingTable(myId)
+		candidates := make([]string, 0)
+		failed := make(map[string]bool)
+
+		for i := 0; i < 500; i++ {
+			id := idWithPrefix(myId, rand.Intn(24))
+			if rand.Intn(4) == 0 {
+				id = dht.GenNodeId()
+			}
+			rt.InsertNode(&dht.CompactNode{Address: fmt.Sprintf("10.0.%d.%d:6881", i / 256, i % 256), Id: id})
+			candidates = append(candidates, id)
+		}
+		// 随机让一些节点失败变bad
+		for _, id := range candidates {
+			if rand.Intn(10) == 0 && rt.FindNode(id) != nil {
+				for i := 0; i < dht.MAX_FAIL_TIMES; i++ {
+					rt.Fail(id)
+				}
+				failed[id] = true
+			}
+		}
+
+		for i := 0; i < 20; i++ {
+			target := dht.GenNodeId()
+			if i % 2 == 0 {
+				target = idWithPrefix(myId, rand.Intn(24))
+			}
+			k := 1 + rand.Intn(2 * dht.KNODES)
+
+			expect := bruteForce(rt, candidates, failed, target, k)
+			actual := rt.ClosestNodes(target, k)
+			if len(actual) != len(expect) {
+				fmt.Println("FAIL round", round, "size", len(actual), "expect", len(expect))
+				os.Exit(1)
+			}
+			for j := range actual {
+				if actual[j].Id != expect[j] || actual[j].Id == myId {
+					fmt.Println("FAIL round", round, "index", j)
+					os.Exit(1)
+				}
+			}
+		}
+	}
+	fmt.Println("PASS")
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"github.com/owenliang/dht"
+
+	"fmt"
+	"os"
+)
+
+// 与myId共享前prefixLen位, 第prefixLen位相反的随机ID, 即落在第prefixLen个桶
+func idWithPrefix(myId string, prefixLen int) string {
+	id := []byte(dht.GenNodeId())
+	for i := 0; i < prefixLen; i++ {
+		mask := byte(0x80 >> uint(i % 8))
+		id[i / 8] = id[i / 8] &^ mask | myId[i / 8] & mask
+	}
+	mask := byte(0x80 >> uint(prefixLen % 8))
+	id[prefixLen / 8] = id[prefixLen / 8] &^ mask | ^myId[prefixLen / 8] & mask
+	return string(id)
+}
+
+func check(name string, actual interface{}, expect interface{}) {
+	if actual != expect {
+		fmt.Println("FAIL", name, "actual", actual, "expect", expect)
+		os.Exit(1)
+	}
+}
+
+// 默认限制的路由表, 先插入KNODES + 1个共同前缀为fillerPrefix的节点(各自不同子网),
+// 使路由表分裂出0 ~ fillerPrefix号桶, 多出的1个进入第fillerPrefix个桶的候选
+func newTable(fillerPrefix int) (rt *dht.RoutingTable, myId string, fillers []string) {
+	myId = dht.GenNodeId()
+	rt = dht.NewRoutingTable(myId)
+	rt.SetIPLimits(dht.DefaultIPLimits)
+	for i := 0; i <= dht.KNODES; i++ {
+		id := idWithPrefix(myId, fillerPrefix)
+		rt.InsertNode(&dht.CompactNode{Id: id, Address: fmt.Sprintf("20.0.%d.1:6881", i)})
+		fillers = append(fillers, id)
+	}
+	return
+}
+
+func insert(rt *dht.RoutingTable, id string, address string) bool {
+	return rt.InsertNode(&dht.CompactNode{Id: id, Address: address})
+}
+
+func failBad(rt *dht.RoutingTable, id string) {
+	for i := 0; i < dht.MAX_FAIL_TIMES; i++ {
+		rt.Fail(id)
+	}
+}
+
+func main()  {
+	// 桶内同一IP, 同一子网的上限; 被拒绝的节点也不进入候选
+	{
+		rt, myId, _ := newTable(6)
+		a := idWithPrefix(myId, 0)
+		check("bucket first ip", insert(rt, a, "1.1.1.1:6881"), true)
+		check("bucket same ip", insert(rt, idWithPrefix(myId, 0), "1.1.1.1:6882"), false)
+		check("bucket same ip no replacement", rt.Snapshot().Buckets[0].Replacements, 0)
+
+		// 刷新已有节点不受自身计数影响, 端口变化也可以
+		check("refresh", insert(rt, a, "1.1.1.1:6881"), true)
+		check("refresh new port", insert(rt, a, "1.1.1.1:7000"), true)
+		check("refresh address", rt.FindNode(a).Address, "1.1.1.1:7000")
+
+		check("bucket subnet 2nd", insert(rt, idWithPrefix(myId, 0), "1.1.1.2:6881"), true)
+		check("bucket subnet 3rd", insert(rt, idWithPrefix(myId, 0), "1.1.1.3:6881"), false)
+		check("bucket other subnet", insert(rt, idWithPrefix(myId, 0), "1.1.2.1:6881"), true)
+	}
+
+	// 整个路由表内同一IP的上限, 节点变bad后不再计入
+	{
+		rt, myId, _ := newTable(6)
+		ids := make([]string, 0)
+		for idx := 0; idx < dht.DefaultIPLimits.TablePerIP; idx++ {
+			id := idWithPrefix(myId, idx)
+			check(fmt.Sprintf("table ip bucket %d", idx), insert(rt, id, "5.5.5.5:6881"), true)
+			ids = append(ids, id)
+		}
+		extra := idWithPrefix(myId, dht.DefaultIPLimits.TablePerIP)
+		check("table ip over limit", insert(rt, extra, "5.5.5.5:6881"), false)
+
+		count := rt.NodeCount()
+		failBad(rt, ids[0])
+		check("bad not counted in size", rt.NodeCount(), count - 1)
+		check("bad status", rt.Snapshot().Buckets[0].Nodes[0].Status, "bad")
+		check("table ip after bad", insert(rt, extra, "5.5.5.5:6881"), true)
+	}
+
+	// 整个路由表内同一子网的上限: 每个桶2个, 分布在多个桶
+	{
+		rt, myId, _ := newTable(10)
+		limit := dht.DefaultIPLimits.TablePerSubnet
+		for i := 0; i < limit; i++ {
+			address := fmt.Sprintf("6.6.6.%d:6881", i + 1)
+			check(fmt.Sprintf("table subnet %d", i), insert(rt, idWithPrefix(myId, i / 2), address), true)
+		}
+		check("table subnet over limit", insert(rt, idWithPrefix(myId, limit / 2), fmt.Sprintf("6.6.6.%d:6881", limit + 1)), false)
+	}
+
+	// 桶满时bad节点由候选替换: bad节点的IP不再计入, 候选的IP开始计入
+	{
+		rt, myId, fillers := newTable(6)
+		bad, promoted := fillers[0], fillers[dht.KNODES]
+		check("replacement waiting", rt.FindNode(promoted) == nil, true)
+		check("replacement count", rt.Snapshot().Buckets[6].Replacements, 1)
+
+		failBad(rt, bad)
+		check("bad removed", rt.FindNode(bad) == nil, true)
+		check("replacement promoted", rt.FindNode(promoted) != nil, true)
+		check("replacement consumed", rt.Snapshot().Buckets[6].Replacements, 0)
+
+		// 20.0.0.1已不在表中, 还可以放TablePerIP个
+		for idx := 0; idx < dht.DefaultIPLimits.TablePerIP; idx++ {
+			check(fmt.Sprintf("bad ip reused bucket %d", idx), insert(rt, idWithPrefix(myId, idx), "20.0.0.1:6881"), true)
+		}
+		check("bad ip over limit", insert(rt, idWithPrefix(myId, dht.DefaultIPLimits.TablePerIP), "20.0.0.1:6881"), false)
+
+		// 20.0.8.1已占用1个
+		for idx := 0; idx < dht.DefaultIPLimits.TablePerIP - 1; idx++ {
+			check(fmt.Sprintf("promoted ip bucket %d", idx), insert(rt, idWithPrefix(myId, idx), "20.0.8.1:6881"), true)
+		}
+		check("promoted ip over limit", insert(rt, idWithPrefix(myId, dht.DefaultIPLimits.TablePerIP - 1), "20.0.8.1:6881"), false)
+	}
+	fmt.Println("PASS")
+}
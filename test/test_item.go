@@ -0,0 +1,67 @@
+package main
+
+import (
+	"github.com/owenliang/dht"
+
+	"os"
+	"fmt"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+)
+
+func main()  {
+	var (
+		options *dht.Options
+		nodes []*dht.DHTNode
+		node *dht.DHTNode
+		item *dht.Item
+		got *dht.Item
+		stored []*dht.CompactNode
+		err error
+	)
+
+	// 进程内组建一个30节点的小网络
+	options = dht.DefaultOptions()
+	options.ListenAddr = "127.0.0.1"
+	options.ListenPort = 0
+	options.PeerSink = nil
+	for i := 0; i < 30; i++ {
+		if node, err = dht.NewNode(options); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer node.Close()
+		nodes = append(nodes, node)
+	}
+	for i, node := range nodes {
+		for j := 1; j <= 5; j++ {
+			neighbor := nodes[(i + j * 7) % len(nodes)]
+			node.RoutingTable().InsertNode(dht.NewCompactNode(neighbor.Id(), neighbor.KRPC().LocalAddr()))
+		}
+	}
+
+	// 不可变item, 以内容的哈希为target
+	item = dht.NewImmutableItem("Hello World!")
+	stored, err = nodes[0].PutItem(context.Background(), item)
+	fmt.Println("Put immutable", len(stored), err)
+	target, _ := item.Target()
+	got, err = nodes[1].GetItem(context.Background(), target, "")
+	fmt.Println("Get immutable", got.Value, err)
+
+	// 可变item, 以公钥+salt为target, seq递增才能覆盖
+	_, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	item, _ = dht.NewMutableItem("feed-v1", privateKey, "feed", 1)
+	stored, err = nodes[2].PutItem(context.Background(), item)
+	fmt.Println("Put mutable seq=1", len(stored), err)
+	item, _ = dht.NewMutableItem("feed-v2", privateKey, "feed", 2)
+	stored, err = nodes[3].PutItem(context.Background(), item)
+	fmt.Println("Put mutable seq=2", len(stored), err)
+	item, _ = dht.NewMutableItem("feed-v0", privateKey, "feed", 0)
+	stored, err = nodes[4].PutItem(context.Background(), item)
+	fmt.Println("Put mutable seq=0", len(stored), err)
+
+	target = dht.MutableTarget(item.Key, "feed")
+	got, err = nodes[5].GetItem(context.Background(), target, "feed")
+	fmt.Println("Get mutable", got.Value, got.Seq, err)
+}
@@ -56,7 +56,12 @@ func main()  {
 		// announce peer
 		announcePeerRequest = dht.NewAnnouncePeerRequest()
 		announcePeerRequest.InfoHash = dht.GenNodeId() // 随机仿造一个20字节的info_hash
-		announcePeerRequest.Token = dht.GenNodeId() // 随机伪造一个token
+		announcePeerRequest.Token = dht.GenNodeId() // 随机伪造一个token, 对方会回复错误
+		if getPeersResponse != nil {
+			announcePeerRequest.InfoHash = getPeersRequest.InfoHash
+			announcePeerRequest.Token = getPeersResponse.Token
+		}
+		announcePeerRequest.Port = 6881
 		announcePeerResponse, err = krpc.AnnouncePeer(context.Background(), announcePeerRequest, address)
 		fmt.Println("AnnouncePeer", announcePeerResponse, err)
 	}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"github.com/owenliang/dht"
+
+	"os"
+	"fmt"
+	"context"
+	"encoding/hex"
+)
+
+func main()  {
+	var (
+		options *dht.Options
+		nodes []*dht.DHTNode
+		node *dht.DHTNode
+		result *dht.LookupResult
+		err error
+	)
+
+	// 进程内组建一个50节点的小网络, 每个节点只认识5个邻居
+	options = dht.DefaultOptions()
+	options.ListenAddr = "127.0.0.1"
+	options.ListenPort = 0
+	options.PeerSink = nil
+	for i := 0; i < 50; i++ {
+		if node, err = dht.NewNode(options); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer node.Close()
+		nodes = append(nodes, node)
+	}
+	for i, node := range nodes {
+		for j := 1; j <= 5; j++ {
+			neighbor := nodes[(i + j * 7) % len(nodes)]
+			node.RoutingTable().InsertNode(dht.NewCompactNode(neighbor.Id(), neighbor.KRPC().LocalAddr()))
+		}
+	}
+
+	// 迭代find_node能找到任意节点
+	target := nodes[len(nodes) - 1].Id()
+	result, err = nodes[0].FindNodeLookup(context.Background(), target)
+	fmt.Println("FindNodeLookup", err, "found:", len(result.Nodes) > 0 && result.Nodes[0].Id == target)
+
+	// announce后其他节点可以通过迭代get_peers找到peer
+	infoHash := dht.GenNodeId()
+	announced, err := nodes[1].Announce(context.Background(), infoHash, 6881)
+	fmt.Println("Announce", hex.EncodeToString([]byte(infoHash)), len(announced), err)
+	result, err = nodes[2].GetPeersLookup(context.Background(), infoHash)
+	fmt.Println("GetPeersLookup", result.Peers, err)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"github.com/owenliang/dht"
+
+	"os"
+	"fmt"
+	"context"
+	"encoding/hex"
+)
+
+func main()  {
+	var (
+		options *dht.Options
+		nodeA *dht.DHTNode
+		nodeB *dht.DHTNode
+		pingResponse *dht.PingResponse
+		err error
+	)
+
+	// 同一进程内的两个独立节点
+	options = dht.DefaultOptions()
+	options.ListenAddr = "127.0.0.1"
+	options.ListenPort = 0
+	if nodeA, err = dht.NewNode(options); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer nodeA.Close()
+	if nodeB, err = dht.NewNode(options); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer nodeB.Close()
+
+	fmt.Println("A", hex.EncodeToString([]byte(nodeA.Id())), nodeA.KRPC().LocalAddr())
+	fmt.Println("B", hex.EncodeToString([]byte(nodeB.Id())), nodeB.KRPC().LocalAddr())
+
+	// A ping B, B的路由表中会出现A
+	pingResponse, err = nodeA.KRPC().Ping(context.Background(), dht.NewPingRequest(), nodeB.KRPC().LocalAddr().String())
+	fmt.Println("Ping", pingResponse, err)
+	fmt.Println("B knows A", nodeB.RoutingTable().FindNode(nodeA.Id()))
+	// 应答方也会进入A的路由表
+	fmt.Println("A knows B", nodeA.RoutingTable().FindNode(nodeB.Id()))
+
+	// B下线后, A的请求连续超时, B被标记为bad
+	address := nodeB.KRPC().LocalAddr().String()
+	nodeB.Close()
+	for i := 0; i < dht.MAX_FAIL_TIMES; i++ {
+		_, err = nodeA.KRPC().Ping(context.Background(), dht.NewPingRequest(), address)
+		fmt.Println("Ping", err)
+	}
+	for _, bucket := range nodeA.RoutingTable().Snapshot().Buckets {
+		for _, node := range bucket.Nodes {
+			fmt.Println("B", node.Status, node.FailTimes)
+		}
+	}
+}
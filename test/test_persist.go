@@ -0,0 +1,65 @@
+package main
+
+import (
+	"github.com/owenliang/dht"
+
+	"os"
+	"fmt"
+	"time"
+	"io/ioutil"
+	"path/filepath"
+	"encoding/hex"
+)
+
+func main()  {
+	var (
+		options *dht.Options
+		nodeA *dht.DHTNode
+		nodeB *dht.DHTNode
+		dead *dht.DHTNode
+		restarted *dht.DHTNode
+		state *dht.RoutingTableState
+		err error
+	)
+
+	dir, _ := ioutil.TempDir("", "dht")
+	defer os.RemoveAll(dir)
+
+	options = dht.DefaultOptions()
+	options.ListenAddr = "127.0.0.1"
+	options.ListenPort = 0
+	options.PeerSink = nil
+	if nodeB, err = dht.NewNode(options); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer nodeB.Close()
+	if dead, err = dht.NewNode(options); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// A认识B和一个即将下线的节点, 关闭时保存路由表
+	options.StateFile = filepath.Join(dir, "routing.dat")
+	if nodeA, err = dht.NewNode(options); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	nodeA.RoutingTable().InsertNode(dht.NewCompactNode(nodeB.Id(), nodeB.KRPC().LocalAddr()))
+	nodeA.RoutingTable().InsertNode(dht.NewCompactNode(dead.Id(), dead.KRPC().LocalAddr()))
+	nodeA.Close()
+	dead.Close()
+
+	state, err = dht.LoadRoutingTableState(options.StateFile)
+	fmt.Println("Saved", hex.EncodeToString([]byte(state.Id)), len(state.Nodes), err)
+
+	// 重启后沿用原来的ID, 只有ping应答的节点进入路由表
+	if restarted, err = dht.NewNode(options); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer restarted.Close()
+	time.Sleep(time.Duration(2) * time.Second)
+	fmt.Println("Same id", restarted.Id() == nodeA.Id())
+	fmt.Println("Restored", restarted.RoutingTable().NodeCount(), restarted.RoutingTable().FindNode(nodeB.Id()) != nil)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/owenliang/dht"
+
+	"os"
+	"fmt"
+	"context"
+)
+
+func main()  {
+	var (
+		options *dht.Options
+		readOnly *dht.DHTNode
+		normal *dht.DHTNode
+		err error
+	)
+
+	options = dht.DefaultOptions()
+	options.ListenAddr = "127.0.0.1"
+	options.ListenPort = 0
+	options.PeerSink = nil
+	if normal, err = dht.NewNode(options); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer normal.Close()
+	options.ReadOnly = true
+	if readOnly, err = dht.NewNode(options); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer readOnly.Close()
+
+	// 只读节点可以正常请求, 但不会进入对方的路由表
+	_, err = readOnly.KRPC().Ping(context.Background(), dht.NewPingRequest(), normal.KRPC().LocalAddr().String())
+	fmt.Println("Ping normal", err)
+	fmt.Println("normal knows read-only", normal.RoutingTable().FindNode(readOnly.Id()) != nil)
+	fmt.Println("read-only knows normal", readOnly.RoutingTable().FindNode(normal.Id()) != nil)
+
+	// 只读节点不应答请求
+	_, err = normal.KRPC().Ping(context.Background(), dht.NewPingRequest(), readOnly.KRPC().LocalAddr().String())
+	fmt.Println("Ping read-only", err)
+}
@@ -13,7 +13,7 @@ func main()  {
 		if size != rt.Size() {
 			size = rt.Size()
 			fmt.Println(size)
-			fmt.Println(rt.ClosestNodes(dht.MyNodeId()))
+			fmt.Println(rt.ClosestNodes(dht.MyNodeId(), dht.KNODES))
 		}
 	}
 }
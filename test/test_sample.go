@@ -0,0 +1,48 @@
+package main
+
+import (
+	"github.com/owenliang/dht"
+
+	"os"
+	"fmt"
+	"context"
+)
+
+func main()  {
+	var (
+		options *dht.Options
+		nodeA *dht.DHTNode
+		nodeB *dht.DHTNode
+		request *dht.SampleInfohashesRequest
+		response *dht.SampleInfohashesResponse
+		err error
+	)
+
+	options = dht.DefaultOptions()
+	options.ListenAddr = "127.0.0.1"
+	options.ListenPort = 0
+	options.PeerSink = nil
+	if nodeA, err = dht.NewNode(options); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer nodeA.Close()
+	if nodeB, err = dht.NewNode(options); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer nodeB.Close()
+
+	// B保存了30个infohash, 一次最多返回MAX_SAMPLES个
+	for i := 0; i < 30; i++ {
+		nodeB.PeerStore().AddPeer(dht.GenNodeId(), "1.2.3.4:6881", false)
+	}
+	request = dht.NewSampleInfohashesRequest()
+	request.Target = dht.GenNodeId()
+	response, err = nodeA.KRPC().SampleInfohashes(context.Background(), request, nodeB.KRPC().LocalAddr().String())
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("Num", response.Num, "Samples", len(response.Samples), "Interval", response.Interval, "Nodes", len(response.Nodes))
+}
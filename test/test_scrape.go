@@ -0,0 +1,65 @@
+package main
+
+import (
+	"github.com/owenliang/dht"
+
+	"os"
+	"fmt"
+	"context"
+	"time"
+)
+
+func main()  {
+	var (
+		options *dht.Options
+		nodeA *dht.DHTNode
+		nodeB *dht.DHTNode
+		infoHash string
+		request *dht.GetPeersRequest
+		response *dht.GetPeersResponse
+		result *dht.ScrapeResult
+		err error
+	)
+
+	options = dht.DefaultOptions()
+	options.ListenAddr = "127.0.0.1"
+	options.ListenPort = 0
+	options.PeerSink = nil
+	if nodeA, err = dht.NewNode(options); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer nodeA.Close()
+	if nodeB, err = dht.NewNode(options); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer nodeB.Close()
+
+	// B保存了100个做种者和300个下载者
+	infoHash = dht.GenNodeId()
+	for i := 0; i < 400; i++ {
+		nodeB.PeerStore().AddPeer(infoHash, fmt.Sprintf("10.0.%d.%d:6881", i / 256, i % 256), i < 100)
+	}
+
+	// 直接请求, noseed=1时values中没有做种者
+	request = dht.NewGetPeersRequest()
+	request.InfoHash = infoHash
+	request.Scrape = 1
+	request.NoSeed = 1
+	response, err = nodeA.KRPC().GetPeers(context.Background(), request, nodeB.KRPC().LocalAddr().String())
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("Values", len(response.Values), "BFsd", response.BFsd.Estimate(), "BFpe", response.BFpe.Estimate())
+
+	// 应答后B已进入A的路由表, 迭代查询并合并过滤器
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(10) * time.Second)
+	defer cancel()
+	if result, err = nodeA.Scrape(ctx, infoHash); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("Nodes", len(result.Nodes), "Seeders", result.Seeders, "Leechers", result.Leechers)
+}
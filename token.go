@@ -9,6 +9,9 @@ import (
 type TokenManager struct {
 	mutex sync.Mutex
 	tokens [2]string
+
+	closeOnce sync.Once
+	closeNotify chan byte // 通知停止刷新
 }
 
 func genToken() string {
@@ -21,8 +24,15 @@ func genToken() string {
 }
 
 func (mgr *TokenManager)refreshToken() {
+	ticker := time.NewTicker(time.Duration(5) * time.Minute)
+	defer ticker.Stop()
+
 	for {
-		time.Sleep(time.Duration(5) * time.Minute)
+		select {
+		case <- ticker.C:
+		case <- mgr.closeNotify:
+			return
+		}
 
 		mgr.mutex.Lock()
 		mgr.tokens[0] = mgr.tokens[1]
@@ -31,20 +41,33 @@ func (mgr *TokenManager)refreshToken() {
 	}
 }
 
+// 5分钟刷新一次token, 生成的token10分钟内有效
+func CreateTokenManager() *TokenManager {
+	mgr := &TokenManager{}
+	mgr.tokens[0] = genToken()
+	mgr.tokens[1] = genToken()
+	mgr.closeNotify = make(chan byte)
+	go mgr.refreshToken()
+	return mgr
+}
+
 var myTokenMgr *TokenManager
 var initTokenMgrOnce sync.Once
 
-// 5分钟刷新一次token, 生成的token10分钟内有效
 func GetTokenManager() *TokenManager {
 	initTokenMgrOnce.Do(func() {
-		myTokenMgr = &TokenManager{}
-		myTokenMgr.tokens[0] = genToken()
-		myTokenMgr.tokens[1] = genToken()
-		go myTokenMgr.refreshToken()
+		myTokenMgr = CreateTokenManager()
 	})
 	return myTokenMgr
 }
 
+// 停止刷新token, 已生成的token仍然可以校验
+func (mgr *TokenManager) Close() {
+	mgr.closeOnce.Do(func() {
+		close(mgr.closeNotify)
+	})
+}
+
 func (mgr *TokenManager) ValidateToken(token string) bool {
 	mgr.mutex.Lock()
 	defer mgr.mutex.Unlock()
@@ -62,4 +85,4 @@ func (mgr *TokenManager) GetToken() string {
 	defer mgr.mutex.Unlock()
 
 	return mgr.tokens[1]
-}
\ No newline at end of file
+}